@@ -64,6 +64,46 @@ resource "aws_apigatewayv2_route" "api" {
   route_key = "ANY /api"
   target    = "integrations/${aws_apigatewayv2_integration.http.id}"
 }
+resource "aws_apigatewayv2_route" "stats" {
+  api_id    = aws_apigatewayv2_api.http.id
+  route_key = "ANY /stats"
+  target    = "integrations/${aws_apigatewayv2_integration.http.id}"
+}
+resource "aws_apigatewayv2_route" "key" {
+  api_id    = aws_apigatewayv2_api.http.id
+  route_key = "ANY /key"
+  target    = "integrations/${aws_apigatewayv2_integration.http.id}"
+}
+resource "aws_apigatewayv2_route" "delete" {
+  api_id    = aws_apigatewayv2_api.http.id
+  route_key = "POST /delete"
+  target    = "integrations/${aws_apigatewayv2_integration.http.id}"
+}
+resource "aws_apigatewayv2_route" "export" {
+  api_id    = aws_apigatewayv2_api.http.id
+  route_key = "POST /export"
+  target    = "integrations/${aws_apigatewayv2_integration.http.id}"
+}
+resource "aws_apigatewayv2_route" "status" {
+  api_id    = aws_apigatewayv2_api.http.id
+  route_key = "POST /status"
+  target    = "integrations/${aws_apigatewayv2_integration.http.id}"
+}
+resource "aws_apigatewayv2_route" "tokens" {
+  api_id    = aws_apigatewayv2_api.http.id
+  route_key = "ANY /tokens"
+  target    = "integrations/${aws_apigatewayv2_integration.http.id}"
+}
+resource "aws_apigatewayv2_route" "healthz" {
+  api_id    = aws_apigatewayv2_api.http.id
+  route_key = "GET /healthz"
+  target    = "integrations/${aws_apigatewayv2_integration.http.id}"
+}
+resource "aws_apigatewayv2_route" "readyz" {
+  api_id    = aws_apigatewayv2_api.http.id
+  route_key = "GET /readyz"
+  target    = "integrations/${aws_apigatewayv2_integration.http.id}"
+}
 resource "aws_apigatewayv2_route" "ws-redirect" {
   api_id    = aws_apigatewayv2_api.http.id
   route_key = "ANY /ws"
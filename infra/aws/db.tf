@@ -51,4 +51,90 @@ resource "aws_dynamodb_table" "notification-table" {
     projection_type = "ALL"
     hash_key        = "credentials"
   }
-}
\ No newline at end of file
+}
+resource "aws_dynamodb_table" "escalation-table" {
+  name         = var.IS_DEV ? "dev-escalation" : "escalation"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "uuid"
+
+  attribute {
+    name = "uuid"
+    type = "S"
+  }
+
+  attribute {
+    name = "credentials"
+    type = "S"
+  }
+
+  global_secondary_index {
+    name            = "credentials-index"
+    projection_type = "ALL"
+    hash_key        = "credentials"
+  }
+}
+
+resource "aws_dynamodb_table" "rate-limit-table" {
+  name         = var.IS_DEV ? "dev-rate-limit" : "rate-limit"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "key"
+
+  attribute {
+    name = "key"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires"
+    enabled        = true
+  }
+}
+
+resource "aws_dynamodb_table" "usage-table" {
+  name         = var.IS_DEV ? "dev-usage" : "usage"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "credentials"
+  range_key    = "period"
+
+  attribute {
+    name = "credentials"
+    type = "S"
+  }
+
+  attribute {
+    name = "period"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "expires"
+    enabled        = true
+  }
+}
+
+resource "aws_dynamodb_table" "token-table" {
+  name         = var.IS_DEV ? "dev-token" : "token"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "token"
+
+  attribute {
+    name = "token"
+    type = "S"
+  }
+
+  attribute {
+    name = "credentials"
+    type = "S"
+  }
+
+  global_secondary_index {
+    name            = "credentials-index"
+    projection_type = "ALL"
+    hash_key        = "credentials"
+  }
+
+  ttl {
+    attribute_name = "expires"
+    enabled        = true
+  }
+}
@@ -42,4 +42,48 @@ resource "aws_iam_role_policy" "lambda_db_user_policy" {
   policy = templatefile("${path.module}/templates/policy.tpl", {
     table_arn = aws_dynamodb_table.user-table.arn
   })
-}
\ No newline at end of file
+}
+resource "aws_iam_role_policy" "lambda_db_escalation_policy" {
+  role = aws_iam_role.iam_for_lambda.id
+  policy = templatefile("${path.module}/templates/policy.tpl", {
+    table_arn = aws_dynamodb_table.escalation-table.arn
+  })
+}
+
+resource "aws_iam_role_policy" "lambda_db_rate_limit_policy" {
+  role = aws_iam_role.iam_for_lambda.id
+  policy = templatefile("${path.module}/templates/policy.tpl", {
+    table_arn = aws_dynamodb_table.rate-limit-table.arn
+  })
+}
+
+resource "aws_iam_role_policy" "lambda_db_usage_policy" {
+  role = aws_iam_role.iam_for_lambda.id
+  policy = templatefile("${path.module}/templates/policy.tpl", {
+    table_arn = aws_dynamodb_table.usage-table.arn
+  })
+}
+
+resource "aws_iam_role_policy" "lambda_db_token_policy" {
+  role = aws_iam_role.iam_for_lambda.id
+  policy = templatefile("${path.module}/templates/policy.tpl", {
+    table_arn = aws_dynamodb_table.token-table.arn
+  })
+}
+
+# kms
+resource "aws_iam_role_policy" "lambda_kms_notification_policy" {
+  role   = aws_iam_role.iam_for_lambda.id
+  policy = <<EOF
+{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": ["kms:Encrypt", "kms:Decrypt"],
+      "Resource": "${aws_kms_key.notification.arn}"
+    }
+  ]
+}
+EOF
+}
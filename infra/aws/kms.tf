@@ -0,0 +1,4 @@
+resource "aws_kms_key" "notification" {
+  description         = var.IS_DEV ? "notifi-dev notification data keys" : "notifi notification data keys"
+  enable_key_rotation = true
+}
@@ -8,9 +8,21 @@ resource "aws_lambda_function" "connect" {
   }
   environment {
     variables = {
+      ADDITIONAL_SERVER_KEYS  = var.ADDITIONAL_SERVER_KEYS
       ENCRYPTION_KEY          = var.ENCRYPTION_KEY
+      ENCRYPTION_KEY_ID       = var.ENCRYPTION_KEY_ID
+      ESCALATION_TABLE_NAME   = aws_dynamodb_table.escalation-table.name
+      KEY_PROVIDER            = "kms"
+      KMS_KEY_ID              = aws_kms_key.notification.arn
+      LOOKUP_KEY              = var.LOOKUP_KEY
       NOTIFICATION_TABLE_NAME = aws_dynamodb_table.notification-table.name
+      PASSWORD_HASH           = var.PASSWORD_HASH
+      RATE_LIMIT_STORE        = "dynamo"
+      RATE_LIMIT_TABLE_NAME   = aws_dynamodb_table.rate-limit-table.name
+      RETIRED_ENCRYPTION_KEYS = var.RETIRED_ENCRYPTION_KEYS
       SERVER_KEY              = var.SERVER_KEY
+      TOKEN_TABLE_NAME        = aws_dynamodb_table.token-table.name
+      USAGE_TABLE_NAME        = aws_dynamodb_table.usage-table.name
       USER_TABLE_NAME         = aws_dynamodb_table.user-table.name
       WS_ENDPOINT             = local.AWS_WS_ENDPOINT
     }
@@ -58,8 +70,16 @@ resource "aws_lambda_function" "message" {
   environment {
     variables = {
       ENCRYPTION_KEY          = var.ENCRYPTION_KEY
+      ENCRYPTION_KEY_ID       = var.ENCRYPTION_KEY_ID
+      ESCALATION_TABLE_NAME   = aws_dynamodb_table.escalation-table.name
+      KEY_PROVIDER            = "kms"
+      KMS_KEY_ID              = aws_kms_key.notification.arn
+      LOOKUP_KEY              = var.LOOKUP_KEY
       NOTIFICATION_TABLE_NAME = aws_dynamodb_table.notification-table.name
+      RETIRED_ENCRYPTION_KEYS = var.RETIRED_ENCRYPTION_KEYS
       SERVER_KEY              = var.SERVER_KEY
+      TOKEN_TABLE_NAME        = aws_dynamodb_table.token-table.name
+      USAGE_TABLE_NAME        = aws_dynamodb_table.usage-table.name
       USER_TABLE_NAME         = aws_dynamodb_table.user-table.name
       WS_ENDPOINT             = local.AWS_WS_ENDPOINT
     }
@@ -83,10 +103,22 @@ resource "aws_lambda_function" "http" {
   }
   environment {
     variables = {
+      ADDITIONAL_SERVER_KEYS        = var.ADDITIONAL_SERVER_KEYS
       ENCRYPTION_KEY                = var.ENCRYPTION_KEY
+      ENCRYPTION_KEY_ID             = var.ENCRYPTION_KEY_ID
+      ESCALATION_TABLE_NAME         = aws_dynamodb_table.escalation-table.name
       FIREBASE_CREDENTIALS_JSON_B64 = var.FIREBASE_CREDENTIALS_JSON_B64
+      KEY_PROVIDER                  = "kms"
+      KMS_KEY_ID                    = aws_kms_key.notification.arn
+      LOOKUP_KEY                    = var.LOOKUP_KEY
       NOTIFICATION_TABLE_NAME       = aws_dynamodb_table.notification-table.name
+      PASSWORD_HASH                 = var.PASSWORD_HASH
+      RATE_LIMIT_STORE              = "dynamo"
+      RATE_LIMIT_TABLE_NAME         = aws_dynamodb_table.rate-limit-table.name
+      RETIRED_ENCRYPTION_KEYS       = var.RETIRED_ENCRYPTION_KEYS
       SERVER_KEY                    = var.SERVER_KEY
+      TOKEN_TABLE_NAME              = aws_dynamodb_table.token-table.name
+      USAGE_TABLE_NAME              = aws_dynamodb_table.usage-table.name
       USER_TABLE_NAME               = aws_dynamodb_table.user-table.name
       WS_ENDPOINT                   = local.AWS_WS_ENDPOINT
       WS_HOST                       = local.WS_DOMAIN
@@ -98,4 +130,42 @@ resource "aws_lambda_permission" "http" {
   action        = "lambda:InvokeFunction"
   function_name = aws_lambda_function.http.function_name
   principal     = "apigateway.amazonaws.com"
-}
\ No newline at end of file
+}
+resource "aws_lambda_function" "escalate" {
+  function_name = var.IS_DEV ? "notifi-escalate-dev" : "notifi-escalate"
+  role          = aws_iam_role.iam_for_lambda.arn
+  image_uri     = local.IMAGE_URI
+  package_type  = "Image"
+  image_config {
+    entry_point = ["/main", "escalate"]
+  }
+  environment {
+    variables = {
+      ENCRYPTION_KEY                = var.ENCRYPTION_KEY
+      ENCRYPTION_KEY_ID             = var.ENCRYPTION_KEY_ID
+      ESCALATION_TABLE_NAME         = aws_dynamodb_table.escalation-table.name
+      FIREBASE_CREDENTIALS_JSON_B64 = var.FIREBASE_CREDENTIALS_JSON_B64
+      KEY_PROVIDER                  = "kms"
+      KMS_KEY_ID                    = aws_kms_key.notification.arn
+      RETIRED_ENCRYPTION_KEYS       = var.RETIRED_ENCRYPTION_KEYS
+      USAGE_TABLE_NAME              = aws_dynamodb_table.usage-table.name
+      USER_TABLE_NAME               = aws_dynamodb_table.user-table.name
+      WS_ENDPOINT                   = local.AWS_WS_ENDPOINT
+    }
+  }
+}
+resource "aws_cloudwatch_event_rule" "escalate" {
+  name                = var.IS_DEV ? "notifi-escalate-dev" : "notifi-escalate"
+  schedule_expression = "rate(1 minute)"
+}
+resource "aws_cloudwatch_event_target" "escalate" {
+  rule = aws_cloudwatch_event_rule.escalate.name
+  arn  = aws_lambda_function.escalate.arn
+}
+resource "aws_lambda_permission" "escalate" {
+  statement_id  = "AllowExecutionFromCloudWatch"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.escalate.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.escalate.arn
+}
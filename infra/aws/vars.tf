@@ -18,10 +18,39 @@ variable "ENCRYPTION_KEY" {
   type = string
 }
 
+variable "ENCRYPTION_KEY_ID" {
+  type    = string
+  default = "1"
+}
+
+# json object of key id to key of previous ENCRYPTION_KEYs
+variable "RETIRED_ENCRYPTION_KEYS" {
+  type    = string
+  default = ""
+}
+
+# key of the HMAC device uuids and credentials are stored under, at least 32 bytes
+variable "LOOKUP_KEY" {
+  type    = string
+  default = ""
+}
+
+# bcrypt or argon2id
+variable "PASSWORD_HASH" {
+  type    = string
+  default = "bcrypt"
+}
+
 variable "SERVER_KEY" {
   type = string
 }
 
+# json object of key id to key of the server keys of other client releases
+variable "ADDITIONAL_SERVER_KEYS" {
+  type    = string
+  default = ""
+}
+
 variable "CF_DOMAIN" {
   type = string
 }
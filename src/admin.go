@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/guregu/dynamo"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// AdminUsage describes the admin commands
+const AdminUsage = `usage: lambda-src admin <command> [flags]
+
+commands:
+  users list [-limit n]                        list the stored devices
+  users show <uuid|credentials|hash>           show a device and its usage
+  users delete <uuid|credentials|hash>         delete a device and everything stored for it
+  notifications purge -older-than <duration>   delete queued notifications older than duration [-dry-run]
+  stats                                        count the stored devices, notifications, escalations and tokens
+  rotate-key                                   re-encrypt stored notifications with the current ENCRYPTION_KEY
+  send -credentials <credentials> -title <t>   send a notification [-message m] [-link url] [-image url]
+`
+
+// ErrAdminUsage is returned when an admin command is unknown or is missing arguments
+var ErrAdminUsage = errors.New("invalid admin command")
+
+// adminCommand runs an admin command with its remaining args, writing its output to out
+type adminCommand func(h *Handlers, ctx context.Context, args []string, out io.Writer) error
+
+// adminCommands are the admin commands by name
+var adminCommands = map[string]adminCommand{
+	"users list":          (*Handlers).adminListUsers,
+	"users show":          (*Handlers).adminShowUser,
+	"users delete":        (*Handlers).adminDeleteUser,
+	"notifications purge": (*Handlers).adminPurgeNotifications,
+	"stats":               (*Handlers).adminStats,
+	"rotate-key":          (*Handlers).adminRotateKey,
+	"send":                (*Handlers).adminSend,
+}
+
+// findAdminCommand returns the admin command named by the first one or two args and the args left for it
+func findAdminCommand(args []string) (adminCommand, []string, error) {
+	for words := 2; words > 0; words-- {
+		if len(args) < words {
+			continue
+		}
+		if command, ok := adminCommands[strings.Join(args[:words], " ")]; ok {
+			return command, args[words:], nil
+		}
+	}
+	return nil, nil, ErrAdminUsage
+}
+
+// Admin runs the admin command of args against the configured tables so devices can be investigated without
+// querying DynamoDB by hand
+func (h *Handlers) Admin(ctx context.Context, args []string, out io.Writer) error {
+	command, args, err := findAdminCommand(args)
+	if err != nil {
+		return err
+	}
+	return command(h, ctx, args, out)
+}
+
+// writeJSON writes v to out as indented json
+func writeJSON(out io.Writer, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s\n", b)
+	return err
+}
+
+// findUser returns the user of id, which is either the plain device uuid, the plain credentials or the stored
+// device uuid hash listed by users list
+func findUser(ctx context.Context, db *DB, id string) (User, error) {
+	switch {
+	case IsValidUUID(id):
+		return GetUserByUUID(ctx, db, id)
+	case IsValidCredentials(id):
+		return GetUserByCredentials(ctx, db, id)
+	}
+	var user User
+	err := db.Users().Get("device_uuid", id).OneWithContext(ctx, &user)
+	return user, err
+}
+
+// adminUserArg returns the user of the single id in args
+func (h *Handlers) adminUserArg(ctx context.Context, args []string) (User, error) {
+	if len(args) != 1 {
+		return User{}, ErrAdminUsage
+	}
+	user, err := findUser(ctx, h.DB, args[0])
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return user, fmt.Errorf("no device '%s'", args[0])
+	}
+	return user, err
+}
+
+func (h *Handlers) adminListUsers(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("users list", flag.ContinueOnError)
+	limit := flags.Int("limit", 100, "maximum number of devices to list, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "UUID\tCREDENTIALS\tOS\tVERSION\tCREATED\tLAST LOGIN\tNOTIFICATIONS\tCONNECTED")
+
+	var (
+		user  User
+		count int
+	)
+	iter := h.DB.Users().Scan().Iter()
+	for (*limit == 0 || count < *limit) && iter.NextWithContext(ctx, &user) {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%t\n",
+			user.UUID, user.Credentials, user.OS, user.AppVersion,
+			user.Created.Format(time.RFC3339), user.LastLogin.Format(time.RFC3339),
+			user.NotificationCnt, len(user.ConnectionID) > 0)
+		count++
+		user = User{}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// AdminUser structure of what is stored for a device
+type AdminUser struct {
+	UUID        string `json:"uuid"`
+	Credentials string `json:"credentials"`
+	DeviceExport
+	Plan             string      `json:"plan,omitempty"`
+	Connected        bool        `json:"connected"`
+	Push             bool        `json:"push"`
+	EndToEnd         bool        `json:"end_to_end"`
+	RequireSignature bool        `json:"require_signature"`
+	IssuedCount      int         `json:"credentials_issued"`
+	Queued           int64       `json:"queued"`
+	Usage            *UsageStats `json:"usage,omitempty"`
+}
+
+func (h *Handlers) adminShowUser(ctx context.Context, args []string, out io.Writer) error {
+	user, err := h.adminUserArg(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	show := AdminUser{
+		UUID:             user.UUID,
+		Credentials:      user.Credentials,
+		DeviceExport:     NewDeviceExport(user),
+		Plan:             user.Plan,
+		Connected:        len(user.ConnectionID) > 0,
+		Push:             len(user.FirebaseToken) > 0,
+		EndToEnd:         len(user.PublicKey) > 0,
+		RequireSignature: user.RequireSignature,
+		IssuedCount:      len(user.issuedCredentials()),
+	}
+	if len(h.Config.Tables.Notification) > 0 {
+		for _, credentials := range user.issuedCredentials() {
+			queued, err := h.DB.Notifications().Get("credentials", credentials).Index("credentials-index").CountWithContext(ctx)
+			if err != nil {
+				return err
+			}
+			show.Queued += queued
+		}
+	}
+	if h.DB.UsageEnabled() {
+		stats, err := GetUsageStats(ctx, h.DB, user.Credentials)
+		if err != nil {
+			return err
+		}
+		show.Usage = &stats
+	}
+	return writeJSON(out, show)
+}
+
+func (h *Handlers) adminDeleteUser(ctx context.Context, args []string, out io.Writer) error {
+	if err := h.Config.Require("NOTIFICATION_TABLE_NAME"); err != nil {
+		return err
+	}
+	user, err := h.adminUserArg(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	receipt, err := DeleteUser(ctx, h.Config, h.DB, user)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, receipt)
+}
+
+// PurgeStats structure of the counts of a notification purge
+type PurgeStats struct {
+	Before        string `json:"before"`
+	Notifications int    `json:"notifications"`
+	Escalations   int    `json:"escalations"`
+	DryRun        bool   `json:"dry_run,omitempty"`
+}
+
+func (h *Handlers) adminPurgeNotifications(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("notifications purge", flag.ContinueOnError)
+	olderThan := flags.Duration("older-than", 0, "purge queued notifications sent longer ago than this, e.g. 720h")
+	dryRun := flags.Bool("dry-run", false, "only count the notifications that would be purged")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *olderThan <= 0 {
+		return fmt.Errorf("%w: -older-than must be a positive duration", ErrAdminUsage)
+	}
+	if err := h.Config.Require("NOTIFICATION_TABLE_NAME"); err != nil {
+		return err
+	}
+
+	stats, err := PurgeNotifications(ctx, h.DB, time.Now().UTC().Add(-*olderThan), *dryRun)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, stats)
+}
+
+// PurgeNotifications deletes the queued notifications sent before, along with their escalations. Nothing is deleted
+// on a dryRun.
+func PurgeNotifications(ctx context.Context, db *DB, before time.Time, dryRun bool) (PurgeStats, error) {
+	stats := PurgeStats{Before: before.Format(notificationTimeLayout), DryRun: dryRun}
+
+	var n Notification
+	iter := db.Notifications().Scan().Filter("'time' < ?", stats.Before).Iter()
+	for iter.NextWithContext(ctx, &n) {
+		stats.Notifications++
+		if !dryRun {
+			if err := db.Notifications().Delete("uuid", n.UUID).RunWithContext(ctx); err != nil {
+				return stats, err
+			}
+			if db.EscalationEnabled() {
+				var old Escalation
+				err := db.Escalations().Delete("uuid", n.UUID).If("attribute_exists('uuid')").OldValueWithContext(ctx, &old)
+				if err == nil {
+					stats.Escalations++
+				} else if !dynamo.IsCondCheckFailed(err) {
+					return stats, err
+				}
+			}
+		}
+		n = Notification{}
+	}
+	return stats, iter.Err()
+}
+
+// AdminStats structure of the number of stored rows
+type AdminStats struct {
+	Users         int64 `json:"users"`
+	Connected     int64 `json:"connected"`
+	Notifications int64 `json:"notifications"`
+	Escalations   int64 `json:"escalations"`
+	Tokens        int64 `json:"tokens"`
+}
+
+func (h *Handlers) adminStats(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) > 0 {
+		return ErrAdminUsage
+	}
+
+	db := h.DB
+	var stats AdminStats
+	counts := []struct {
+		enabled bool
+		scan    *dynamo.Scan
+		count   *int64
+	}{
+		{true, db.Users().Scan(), &stats.Users},
+		{true, db.Users().Scan().Index("connection_id-index"), &stats.Connected},
+		{len(h.Config.Tables.Notification) > 0, db.Notifications().Scan(), &stats.Notifications},
+		{db.EscalationEnabled(), db.Escalations().Scan(), &stats.Escalations},
+		{db.TokensEnabled(), db.Tokens().Scan(), &stats.Tokens},
+	}
+	for _, c := range counts {
+		if !c.enabled {
+			continue
+		}
+		count, err := c.scan.CountWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		*c.count = count
+	}
+	return writeJSON(out, stats)
+}
+
+func (h *Handlers) adminRotateKey(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) > 0 {
+		return ErrAdminUsage
+	}
+	if err := h.Config.Require("ENCRYPTION_KEY", "NOTIFICATION_TABLE_NAME"); err != nil {
+		return err
+	}
+
+	stats, err := Reencrypt(ctx, h.DB, h.Envelope)
+	if err != nil {
+		return err
+	}
+	return writeJSON(out, stats)
+}
+
+func (h *Handlers) adminSend(ctx context.Context, args []string, out io.Writer) error {
+	var n Notification
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	flags.StringVar(&n.Credentials, "credentials", "", "credentials to send the notification to")
+	flags.StringVar(&n.Title, "title", "", "title of the notification")
+	flags.StringVar(&n.Message, "message", "", "message of the notification")
+	flags.StringVar(&n.Link, "link", "", "link opened by the notification")
+	flags.StringVar(&n.Image, "image", "", "image shown with the notification")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if err := h.Config.Require("ENCRYPTION_KEY", "WS_ENDPOINT", "NOTIFICATION_TABLE_NAME"); err != nil {
+		return err
+	}
+	if err := n.Validate(ctx); err != nil {
+		return err
+	}
+
+	user, err := GetUserByCredentials(ctx, h.DB, n.Credentials)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return errors.New("no device has these credentials")
+	} else if err != nil {
+		return err
+	}
+
+	n.Credentials = user.Credentials
+	delivered, err := h.Deliver(ctx, &n, user)
+	if err != nil {
+		return err
+	}
+	status := SendQueued
+	if delivered {
+		status = SendDelivered
+	}
+	_, err = fmt.Fprintf(out, "%s notification %s\n", status, n.UUID)
+	return err
+}
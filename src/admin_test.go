@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var findAdminCommandTests = []struct {
+	args  []string
+	found bool
+	rest  []string
+}{
+	{[]string{"users", "list", "-limit", "5"}, true, []string{"-limit", "5"}},
+	{[]string{"users", "show", "foo"}, true, []string{"foo"}},
+	{[]string{"stats"}, true, []string{}},
+	{[]string{"send", "-credentials", "c"}, true, []string{"-credentials", "c"}},
+	{[]string{"users"}, false, nil},
+	{[]string{"users", "foo"}, false, nil},
+	{[]string{}, false, nil},
+}
+
+func TestFindAdminCommand(t *testing.T) {
+	for _, tt := range findAdminCommandTests {
+		command, rest, err := findAdminCommand(tt.args)
+		if tt.found != (command != nil) {
+			t.Errorf("%v: got %v, wanted found %v", tt.args, err, tt.found)
+			continue
+		}
+		if !tt.found && !errors.Is(err, ErrAdminUsage) {
+			t.Errorf("%v: got %v, wanted %v", tt.args, err, ErrAdminUsage)
+		}
+		if strings.Join(rest, " ") != strings.Join(tt.rest, " ") {
+			t.Errorf("%v: got args %v, wanted %v", tt.args, rest, tt.rest)
+		}
+	}
+}
+
+func TestAdminUsageListsCommands(t *testing.T) {
+	for name := range adminCommands {
+		if !strings.Contains(AdminUsage, "  "+name+" ") {
+			t.Errorf("%s is missing from the usage", name)
+		}
+	}
+}
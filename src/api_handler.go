@@ -2,20 +2,26 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"firebase.google.com/go/v4/messaging"
 	"fmt"
-	"github.com/appleboy/go-fcm"
+	"github.com/guregu/dynamo"
 	"github.com/iris-contrib/schema"
 	"github.com/sirupsen/logrus"
 	"net/http"
-	"os"
 )
 
-func HandleApi(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+func (h *Handlers) HandleApi(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if h.IsRateLimited(w, r, "ip:"+RemoteIP(r), h.PlanRateLimit(IPPlan)) {
+		return
+	}
+
+	body, err := ReadSignedBody(w, r)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusBadRequest)
+		return
+	}
 
 	if err := r.ParseForm(); err != nil {
 		WriteHttpError(w, r, err, http.StatusBadRequest)
@@ -28,80 +34,153 @@ func HandleApi(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := notification.Validate(); err != nil {
+	db := h.DB
+	err = notification.Validate(ctx)
+	if err == nil && notification.Escalate && !db.EscalationEnabled() {
+		err = NewValidationError(EscalationReason, "Escalation is not enabled!")
+	}
+	if err != nil {
+		reason := "unknown"
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			reason = validationErr.Reason
+			w.Header().Set(ReasonHeader, reason)
+		}
+		validationFailuresTotal.Inc(reason)
+		RecordRejected(ctx, db, notification.Credentials)
 		WriteHttpError(w, r, err, http.StatusBadRequest)
 		return
 	}
 
-	notification.Credentials = Hash(notification.Credentials)
-
-	// connect to db
-	db, err := GetDB()
+	var user User
+	if IsToken(notification.Credentials) {
+		if !db.TokensEnabled() {
+			WriteSendResult(w, r, UnknownSendResult())
+			return
+		}
+		var token Token
+		token, err = LookupToken(ctx, db, notification.Credentials)
+		if errors.Is(err, ErrTokenNotFound) {
+			WriteSendResult(w, r, UnknownSendResult())
+			return
+		} else if err != nil {
+			WriteHttpError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		if !token.Allows(notification.Topic) {
+			Usage{Rejected: 1}.Record(ctx, db, token.Credentials)
+			WriteHttpError(w, r, fmt.Errorf("Token can not send notifications with topic '%s'!", notification.Topic), http.StatusForbidden)
+			return
+		}
+		// tokens are stored with the hashed credentials they send as
+		err = db.Users().Get("credentials", token.Credentials).Index("credentials-index").OneWithContext(ctx, &user)
+	} else {
+		user, err = GetUserByCredentials(ctx, db, notification.Credentials)
+	}
 	if err != nil {
-		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		WriteSendResult(w, r, UnknownSendResult())
 		return
 	}
 
-	var user User
-	err = db.Table(UserTable).Get("credentials", notification.Credentials).Index("credentials-index").One(&user)
+	notification.Credentials = user.Credentials
+	AddLogFields(ctx, logrus.Fields{"credentials": notification.Credentials})
+	if len(notification.DedupeKey) > 0 {
+		notification.DedupeKey = db.Hasher.Lookup(notification.DedupeKey)
+	}
+
+	if err := h.VerifySignature(r, body, user); errors.Is(err, ErrReplayCheck) {
+		WriteHttpError(w, r, err, http.StatusServiceUnavailable)
+		return
+	} else if err != nil {
+		Usage{Rejected: 1}.Record(ctx, db, user.Credentials)
+		WriteHttpError(w, r, err, http.StatusForbidden)
+		return
+	}
+
+	if h.IsRateLimited(w, r, "credentials:"+user.Credentials, h.PlanRateLimit(user.Plan)) {
+		Usage{Rejected: 1}.Record(ctx, db, user.Credentials)
+		return
+	}
+
+	delivered, err := h.Deliver(ctx, &notification, user)
 	if err != nil {
-		w.WriteHeader(http.StatusOK)
+		code := http.StatusInternalServerError
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			code = http.StatusBadRequest
+			w.Header().Set(ReasonHeader, validationErr.Reason)
+		}
+		WriteHttpError(w, r, err, code)
 		return
 	}
 
+	result := SendResult{UUID: notification.UUID, Status: SendQueued}
+	if delivered {
+		result.Status = SendDelivered
+	}
+	WriteSendResult(w, r, result)
+}
+
+// Deliver counts, sends and stores the validated notification for user, escalating it if asked to, and returns
+// whether it was delivered over the websocket rather than queued. A ValidationError is returned if it can not be sent
+// to user.
+func (h *Handlers) Deliver(ctx context.Context, notification *Notification, user User) (delivered bool, err error) {
+	db := h.DB
+
 	// increase users notification count
-	err = db.Table(UserTable).
+	err = db.Users().
 		Update("device_uuid", user.UUID).
 		SetExpr("notification_cnt = notification_cnt + ?", 1).
-		Run()
+		RunWithContext(ctx)
 	if err != nil {
-		WriteHttpError(w, r, err, http.StatusInternalServerError)
-		return
+		return false, err
+	}
+
+	if len(user.PublicKey) > 0 && len(notification.Ciphertext) == 0 {
+		// the user only accepts end-to-end encrypted notifications so encrypt them on receipt
+		if notification.Escalate && len(notification.EscalateTo) > 0 {
+			return false, NewValidationError(EscalationReason, "End-to-end encrypted notifications can not be escalated to other credentials!")
+		}
+		if err := notification.Seal(user.PublicKey); err != nil {
+			return false, err
+		}
+	} else if len(user.PublicKey) == 0 && len(notification.Ciphertext) > 0 {
+		return false, NewValidationError(CiphertextReason, "The credentials have no public key to encrypt to!")
 	}
 
 	notification.Init()
-	notificationMsgBytes, err := json.Marshal([]Notification{notification})
-	if err != nil {
-		WriteHttpError(w, r, err, http.StatusInternalServerError)
-		return
+	if err := notification.Deduplicate(ctx, h.Dedupes); err != nil {
+		return false, err
 	}
 
-	if len(user.FirebaseToken) > 0 {
-		credentialsJsonB64 := os.Getenv("FIREBASE_CREDENTIALS_JSON_B64")
-		credentialsJson, b64Err := base64.StdEncoding.DecodeString(credentialsJsonB64)
-		if b64Err != nil {
-			logrus.Errorf("Problem decoding firebase message: %s", b64Err.Error())
-			return
+	usage, err := notification.Send(ctx, h.Config, user)
+	usage.Sent++
+	if err != nil {
+		stored := *notification
+		if err := stored.Store(ctx, db, h.Envelope); err != nil {
+			return false, fmt.Errorf("%s %v", err.Error(), *notification)
 		}
-
-		firebaseClient, err := fcm.NewClient(ctx, fcm.WithCredentialsJSON(credentialsJson))
-		if err == nil && firebaseClient != nil {
-			_, err := firebaseClient.Send(ctx, &messaging.Message{
-				Token: user.FirebaseToken,
-				Notification: &messaging.Notification{
-					Title: notification.Title,
-					Body:  notification.Message,
-				},
-			})
-			if err != nil {
-				logrus.Errorf("Problem sending firebase message: %s", err.Error())
-			}
-		} else if err != nil {
-			logrus.Errorf("Problem setting up FB client: %s", err.Error())
+		usage.Queued++
+	} else if notification.Repeats > 0 {
+		// the notification this repeat takes the place of may still be queued with its old content
+		err := db.Notifications().
+			Delete("uuid", notification.UUID).
+			If("'credentials' = ?", user.Credentials).
+			RunWithContext(ctx)
+		if err != nil && !dynamo.IsCondCheckFailed(err) {
+			return false, err
 		}
 	}
+	usage.Record(ctx, db, user.Credentials)
 
-	if len(user.ConnectionID) > 0 {
-		err = SendWsMessage(user.ConnectionID, notificationMsgBytes)
-	} else {
-		err = errors.New("user has no ConnectionID")
-	}
-	if err != nil {
-		var encryptionKey = []byte(os.Getenv("ENCRYPTION_KEY"))
-		if err := notification.Store(db, encryptionKey); err != nil {
-			WriteHttpError(w, r, fmt.Errorf("%s %v", err.Error(), notification), http.StatusInternalServerError)
-			return
+	if notification.Escalate {
+		escalation, err := NewEscalation(ctx, db, *notification, notification.EscalationPolicy(), h.Envelope)
+		if err == nil {
+			err = escalation.Store(ctx, db)
+		}
+		if err != nil {
+			return false, err
 		}
 	}
-	w.WriteHeader(http.StatusOK)
+	return usage.Delivered > 0, nil
 }
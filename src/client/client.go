@@ -0,0 +1,275 @@
+// Package client sends notifications to a notifi backend and receives them over its websocket.
+//
+// A Client sends notifications to /api, retrying temporary failures with exponential backoff, and a Receiver
+// connects to the websocket as a device to receive and acknowledge them:
+//
+//	c := client.New("https://notifi.it")
+//	result, err := c.Send(ctx, client.NewNotification(credentials, "Deployed").WithMessage("v1.2.3"))
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/notifi-backend/lambda-src/signature"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaults of a new Client
+const (
+	DefaultMaxRetries  = 3
+	DefaultMinBackoff  = time.Second
+	DefaultMaxBackoff  = 30 * time.Second
+	DefaultConcurrency = 4
+)
+
+// maxResponseBytes is the most of an error response that is read
+const maxResponseBytes = 64 << 10
+
+// Client sends requests to the http api of a notifi backend
+type Client struct {
+	// Host is the url of the backend e.g. https://notifi.it
+	Host       string
+	HTTPClient *http.Client
+
+	// SigningSecret signs every request if set, for credentials that require signed requests
+	SigningSecret []byte
+
+	// MaxRetries is how many times a request is retried after a network error, 429 or 5xx. Each retry waits twice as
+	// long as the last from MinBackoff up to MaxBackoff, or as long as the server asked with Retry-After if that is
+	// not longer than MaxBackoff. Notifications without a dedupe key are only retried when they can not have been
+	// sent, after a 429 or failing to connect, so they are not sent twice.
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Concurrency is how many notifications of a batch are sent at once
+	Concurrency int
+}
+
+// New creates a Client of the backend at host with the default retries and concurrency
+func New(host string) *Client {
+	return &Client{
+		Host:        strings.TrimSuffix(host, "/"),
+		HTTPClient:  http.DefaultClient,
+		MaxRetries:  DefaultMaxRetries,
+		MinBackoff:  DefaultMinBackoff,
+		MaxBackoff:  DefaultMaxBackoff,
+		Concurrency: DefaultConcurrency,
+	}
+}
+
+// statuses of a sent notification
+const (
+	// Delivered notifications were sent over the websocket of the device or have been read from its queue
+	Delivered = "delivered"
+
+	// Queued notifications are waiting for the device to connect and read them
+	Queued = "queued"
+)
+
+// Result is the status of a sent notification
+type Result struct {
+	UUID   string `json:"UUID"`
+	Status string `json:"status"`
+}
+
+// Send sends n and returns whether it was delivered or queued. The server accepts notifications to unknown
+// credentials without an error, reporting them as queued, so they can not be guessed.
+func (c *Client) Send(ctx context.Context, n *Notification) (Result, error) {
+	var result Result
+	// a notification sent again under its dedupe key takes the place of the first
+	body, err := c.post(ctx, "/api", n.form(), nil, len(n.DedupeKey) > 0)
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, &result)
+	}
+	return result, err
+}
+
+// Status returns the current status of the notification uuid sent with credentials
+func (c *Client) Status(ctx context.Context, credentials, uuid string) (Result, error) {
+	var result Result
+	body, err := c.post(ctx, "/status", url.Values{"credentials": {credentials}, "UUID": {uuid}}, nil, true)
+	if err == nil {
+		err = json.Unmarshal(body, &result)
+	}
+	return result, err
+}
+
+// Wait polls the status of the notification of result sent with credentials every interval until it is delivered
+// or ctx is done
+func (c *Client) Wait(ctx context.Context, credentials string, result Result, interval time.Duration) (Result, error) {
+	for result.Status != Delivered {
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var err error
+		if result, err = c.Status(ctx, credentials, result.UUID); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// BatchError is returned when some notifications of a batch could not be sent
+type BatchError struct {
+	// Errors are the errors of the notifications that failed by their index in the batch
+	Errors map[int]error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("notifi: %d notifications failed to send", len(e.Errors))
+}
+
+// SendBatch sends every notification, Concurrency at a time, and returns their results in the same order. A
+// BatchError is returned if any of them fail.
+func (c *Client) SendBatch(ctx context.Context, notifications []*Notification) ([]Result, error) {
+	concurrency := c.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make([]Result, len(notifications))
+		errs    = map[int]error{}
+		sem     = make(chan struct{}, concurrency)
+	)
+	for i, n := range notifications {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, n *Notification) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result, err := c.Send(ctx, n)
+			results[i] = result
+			if err != nil {
+				mu.Lock()
+				errs[i] = err
+				mu.Unlock()
+			}
+		}(i, n)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return results, &BatchError{Errors: errs}
+	}
+	return results, nil
+}
+
+// Credentials are the credentials issued to a device
+type Credentials struct {
+	Credentials   string `json:"credentials"`
+	Key           string `json:"credential_key"`
+	SigningSecret string `json:"signing_secret,omitempty"`
+}
+
+// Register requests new credentials for the device uuid with the serverKey of the client release. To replace the
+// current credentials of a registered device its current credentials must be passed too.
+func (c *Client) Register(ctx context.Context, serverKey, uuid string, current *Credentials) (Credentials, error) {
+	form := url.Values{"UUID": {uuid}}
+	if current != nil {
+		form.Set("current_credentials", current.Credentials)
+		form.Set("current_credential_key", current.Key)
+	}
+
+	var creds Credentials
+	body, err := c.post(ctx, "/code", form, http.Header{"Sec-Key": {serverKey}}, false)
+	if err == nil {
+		err = json.Unmarshal(body, &creds)
+	}
+	return creds, err
+}
+
+// post posts form to path, retrying temporary failures, and returns the response body. Unless the request is
+// idempotent it is only retried if it can not have been processed.
+func (c *Client) post(ctx context.Context, path string, form url.Values, header http.Header, idempotent bool) ([]byte, error) {
+	body := []byte(form.Encode())
+	for attempt := 0; ; attempt++ {
+		resp, err := c.do(ctx, path, body, header)
+		if err == nil {
+			return resp, nil
+		}
+
+		var apiErr *Error
+		if errors.As(err, &apiErr) && !apiErr.Temporary() || !idempotent && !unprocessed(err) ||
+			attempt >= c.MaxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt)
+		if apiErr != nil && apiErr.RetryAfter > wait {
+			if apiErr.RetryAfter > c.MaxBackoff {
+				// the server asked to wait longer than the client is willing to
+				return nil, err
+			}
+			wait = apiErr.RetryAfter
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// do sends a single post of body to path
+func (c *Client) do(ctx context.Context, path string, body []byte, header http.Header) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Host+path, strings.NewReader(string(body)))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(c.SigningSecret) > 0 {
+		// signed on every attempt as each signature can only be used once
+		req.Header.Set(signature.Header, signature.Sign(c.SigningSecret, req.Method, req.URL.RequestURI(), body, time.Now()))
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, newError(resp, respBody)
+	}
+	return respBody, nil
+}
+
+// backoff returns how long to wait before retrying after attempt, with jitter so clients do not retry in step
+func (c *Client) backoff(attempt int) time.Duration {
+	wait := c.MinBackoff << attempt
+	if wait <= 0 || wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
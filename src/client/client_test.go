@@ -0,0 +1,318 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/notifi-backend/lambda-src/signature"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClient returns a Client of server that retries without waiting
+func testClient(server *httptest.Server) *Client {
+	c := New(server.URL)
+	c.MinBackoff, c.MaxBackoff = time.Millisecond, time.Millisecond
+	return c
+}
+
+func TestSend(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api" || r.Method != http.MethodPost {
+			t.Errorf("got %s %s, wanted POST /api", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{
+			"credentials":       "credentials",
+			"title":             "title",
+			"message":           "message",
+			"link":              "https://notifi.it",
+			"topic":             "deploys",
+			"dedupe_key":        "key",
+			"dedupe_window":     "60",
+			"escalate":          "true",
+			"escalate_interval": "300",
+		}
+		for name, value := range want {
+			if got := r.Form.Get(name); got != value {
+				t.Errorf("got %s=%s, wanted %s", name, got, value)
+			}
+		}
+		if r.Form.Has("image") || r.Form.Has("escalate_max") {
+			t.Errorf("unset fields should not be sent: %v", r.Form)
+		}
+	}))
+	defer server.Close()
+
+	n := NewNotification("credentials", "title").
+		WithMessage("message").
+		WithLink("https://notifi.it").
+		WithTopic("deploys").
+		WithDedupe("key", time.Minute).
+		WithEscalation(5*time.Minute, 0, "")
+	if _, err := testClient(server).Send(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	if _, err := testClient(server).Send(context.Background(), NewNotification("credentials", "title").WithDedupe("key", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 3 {
+		t.Errorf("got %d attempts, wanted 3", attempts)
+	}
+}
+
+func TestSendGivesUp(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := testClient(server)
+	_, err := c.Send(context.Background(), NewNotification("credentials", "title").WithDedupe("key", 0))
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got %v, wanted 500 Error", err)
+	}
+	if int(attempts) != c.MaxRetries+1 {
+		t.Errorf("got %d attempts, wanted %d", attempts, c.MaxRetries+1)
+	}
+}
+
+func TestSendValidationError(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set(ReasonHeader, ReasonTitle)
+		http.Error(w, "Title too long!", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	_, err := testClient(server).Send(context.Background(), NewNotification("credentials", "title"))
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("got %v, wanted Error", err)
+	}
+	if !apiErr.Validation() || apiErr.Reason != ReasonTitle || apiErr.Message != "Title too long!" {
+		t.Errorf("got %+v, wanted title validation error", apiErr)
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, validation errors should not be retried", attempts)
+	}
+}
+
+func TestSendRetryAfter(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many requests!", http.StatusTooManyRequests)
+		}
+	}))
+	defer server.Close()
+
+	c := testClient(server)
+	c.MaxBackoff = 2 * time.Second
+	start := time.Now()
+	if _, err := c.Send(context.Background(), NewNotification("credentials", "title")); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("waited %s, wanted the Retry-After of 1s", waited)
+	}
+}
+
+func TestSendRetryAfterTooLong(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many requests!", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	_, err := testClient(server).Send(context.Background(), NewNotification("credentials", "title"))
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != time.Minute {
+		t.Errorf("got %v, wanted 429 Error to retry after a minute", err)
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, a Retry-After longer than MaxBackoff should not be retried", attempts)
+	}
+}
+
+func TestSendNotRetriedWithoutDedupeKey(t *testing.T) {
+	var attempts int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if _, err := testClient(server).Send(context.Background(), NewNotification("credentials", "title")); err == nil {
+		t.Errorf("500 should have errored")
+	}
+	if attempts != 1 {
+		t.Errorf("got %d attempts, a notification that may have been sent should not be retried", attempts)
+	}
+}
+
+func TestUnprocessed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	c := testClient(server)
+	server.Close()
+
+	if _, err := c.do(context.Background(), "/api", nil, nil); !unprocessed(err) {
+		t.Errorf("got %v, refused connections should be retried", err)
+	}
+	if !unprocessed(&Error{StatusCode: http.StatusTooManyRequests}) || !unprocessed(&Error{StatusCode: StatusLockedOut}) {
+		t.Errorf("rejected requests should be retried")
+	}
+	if unprocessed(&Error{StatusCode: http.StatusBadGateway}) || unprocessed(io.ErrUnexpectedEOF) {
+		t.Errorf("requests that may have been processed should not be retried")
+	}
+}
+
+func TestSendContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := testClient(server)
+	c.MinBackoff, c.MaxBackoff = time.Hour, time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Send(ctx, NewNotification("credentials", "title").WithDedupe("key", 0)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, wanted %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestSendSigned(t *testing.T) {
+	secret := []byte("secret")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := signature.Verify(secret, r.Header.Get(signature.Header), r.Method, r.URL.RequestURI(), body, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	c := testClient(server)
+	c.SigningSecret = secret
+	if _, err := c.Send(context.Background(), NewNotification("credentials", "title")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("title") == "bad" {
+			w.Header().Set(ReasonHeader, ReasonTitle)
+			http.Error(w, "bad title", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"UUID": "uuid", "status": "delivered"}`))
+	}))
+	defer server.Close()
+
+	notifications := []*Notification{
+		NewNotification("credentials", "good"),
+		NewNotification("credentials", "bad"),
+		NewNotification("credentials", "good"),
+	}
+	results, err := testClient(server).SendBatch(context.Background(), notifications)
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("got %v, wanted BatchError", err)
+	}
+	if len(batchErr.Errors) != 1 || batchErr.Errors[1] == nil {
+		t.Errorf("got %v, wanted only the second notification to fail", batchErr.Errors)
+	}
+	if len(results) != len(notifications) || results[0].Status != Delivered {
+		t.Errorf("got %v, wanted a result of each notification", results)
+	}
+}
+
+func TestWait(t *testing.T) {
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api":
+			_, _ = w.Write([]byte(`{"UUID": "uuid", "status": "queued"}`))
+		case "/status":
+			status := Queued
+			if atomic.AddInt32(&polls, 1) == 2 {
+				status = Delivered
+			}
+			_, _ = w.Write([]byte(`{"UUID": "` + r.FormValue("UUID") + `", "status": "` + status + `"}`))
+		}
+	}))
+	defer server.Close()
+
+	c := testClient(server)
+	ctx := context.Background()
+	result, err := c.Send(ctx, NewNotification("credentials", "title"))
+	if err != nil || result.Status != Queued || result.UUID != "uuid" {
+		t.Fatalf("got %+v %v, wanted queued", result, err)
+	}
+	result, err = c.Wait(ctx, "credentials", result, time.Millisecond)
+	if err != nil || result.Status != Delivered || result.UUID != "uuid" {
+		t.Errorf("got %+v %v, wanted delivered", result, err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Wait(timeout, "credentials", Result{UUID: "uuid", Status: Queued}, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, wanted %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/code" || r.Header.Get("Sec-Key") != "server" || r.FormValue("UUID") != "uuid" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.FormValue("current_credentials") != "old" {
+			t.Errorf("got %s, wanted the current credentials", r.FormValue("current_credentials"))
+		}
+		_, _ = w.Write([]byte(`{"credentials": "new", "credential_key": "key"}`))
+	}))
+	defer server.Close()
+
+	creds, err := testClient(server).Register(context.Background(), "server", "uuid", &Credentials{Credentials: "old", Key: "old key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Credentials != "new" || creds.Key != "key" {
+		t.Errorf("got %+v", creds)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	c := New("")
+	for attempt := 0; attempt < 10; attempt++ {
+		wait := c.backoff(attempt)
+		if wait < c.MinBackoff/2 || wait > c.MaxBackoff {
+			t.Errorf("attempt %d: got %s, wanted between %s and %s", attempt, wait, c.MinBackoff/2, c.MaxBackoff)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReasonHeader is the response header the server returns the reason a notification failed validation in
+const ReasonHeader = "X-Notifi-Reason"
+
+// reasons a notification can fail validation
+const (
+	ReasonCredentials = "credentials"
+	ReasonTitle       = "title"
+	ReasonMessage     = "message"
+	ReasonLink        = "link"
+	ReasonImage       = "image"
+	ReasonDedupe      = "dedupe"
+	ReasonEscalation  = "escalation"
+	ReasonSize        = "size"
+	ReasonCiphertext  = "ciphertext"
+	ReasonTopic       = "topic"
+)
+
+// status codes the server returns besides the standard http ones
+const (
+	// StatusRequestNewUser is returned when a device has to request new credentials before connecting
+	StatusRequestNewUser = 551
+
+	// StatusLockedOut is returned when a device is locked out after too many invalid credential keys
+	StatusLockedOut = 552
+)
+
+// Error is returned when the server rejects a request
+type Error struct {
+	StatusCode int
+	Message    string
+
+	// Reason is set to one of the Reason constants when a notification fails validation
+	Reason string
+
+	// RetryAfter is how long the server asked to wait before trying again
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if len(e.Reason) > 0 {
+		return fmt.Sprintf("notifi: %d %s (%s)", e.StatusCode, e.Message, e.Reason)
+	}
+	return fmt.Sprintf("notifi: %d %s", e.StatusCode, e.Message)
+}
+
+// Validation returns whether the notification failed validation
+func (e *Error) Validation() bool {
+	return e.StatusCode == http.StatusBadRequest
+}
+
+// Temporary returns whether the request can succeed if it is retried
+func (e *Error) Temporary() bool {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests, e.StatusCode == StatusLockedOut:
+		return true
+	case e.StatusCode == StatusRequestNewUser:
+		return false
+	}
+	return e.StatusCode >= http.StatusInternalServerError
+}
+
+// unprocessed returns whether err means the request was rejected before the server processed it, so retrying it
+// can not repeat it
+func unprocessed(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode == StatusLockedOut
+	}
+
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// newError creates the Error of resp with its body
+func newError(resp *http.Response, body []byte) *Error {
+	e := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    strings.TrimSpace(string(body)),
+		Reason:     resp.Header.Get(ReasonHeader),
+	}
+	if len(e.Message) == 0 {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
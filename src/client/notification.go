@@ -0,0 +1,103 @@
+package client
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Notification is a notification to send to the credentials of a device, or to a send-only token. Create one with
+// NewNotification and set the optional fields with the With methods.
+type Notification struct {
+	Credentials string
+	Title       string
+	Message     string
+	Link        string
+	Image       string
+	Topic       string
+
+	// DedupeKey collapses notifications with the same key sent within DedupeWindow into one
+	DedupeKey    string
+	DedupeWindow time.Duration
+
+	// Escalate resends the notification every EscalateInterval up to EscalateMax times until it is read, and then
+	// to the EscalateTo credentials
+	Escalate         bool
+	EscalateInterval time.Duration
+	EscalateMax      int
+	EscalateTo       string
+}
+
+// NewNotification creates a Notification with title to credentials
+func NewNotification(credentials, title string) *Notification {
+	return &Notification{Credentials: credentials, Title: title}
+}
+
+// WithMessage sets the message of n
+func (n *Notification) WithMessage(message string) *Notification {
+	n.Message = message
+	return n
+}
+
+// WithLink sets the url opened when n is clicked
+func (n *Notification) WithLink(link string) *Notification {
+	n.Link = link
+	return n
+}
+
+// WithImage sets the url of the image shown with n
+func (n *Notification) WithImage(image string) *Notification {
+	n.Image = image
+	return n
+}
+
+// WithTopic sets the topic of n, which tokens can be restricted to
+func (n *Notification) WithTopic(topic string) *Notification {
+	n.Topic = topic
+	return n
+}
+
+// WithDedupe collapses notifications with key sent within window into n. The server default window is used if
+// window is 0.
+func (n *Notification) WithDedupe(key string, window time.Duration) *Notification {
+	n.DedupeKey, n.DedupeWindow = key, window
+	return n
+}
+
+// WithEscalation resends n every interval up to max times until it is read and then to the to credentials, if set.
+// The server defaults are used for an interval or max of 0.
+func (n *Notification) WithEscalation(interval time.Duration, max int, to string) *Notification {
+	n.Escalate, n.EscalateInterval, n.EscalateMax, n.EscalateTo = true, interval, max, to
+	return n
+}
+
+// form returns the /api form values of n
+func (n *Notification) form() url.Values {
+	form := url.Values{}
+	set := func(name, value string) {
+		if len(value) > 0 {
+			form.Set(name, value)
+		}
+	}
+	set("credentials", n.Credentials)
+	set("title", n.Title)
+	set("message", n.Message)
+	set("link", n.Link)
+	set("image", n.Image)
+	set("topic", n.Topic)
+	set("dedupe_key", n.DedupeKey)
+	if n.DedupeWindow > 0 {
+		set("dedupe_window", strconv.Itoa(int(n.DedupeWindow.Seconds())))
+	}
+	if n.Escalate {
+		set("escalate", "true")
+		if n.EscalateInterval > 0 {
+			set("escalate_interval", strconv.Itoa(int(n.EscalateInterval.Seconds())))
+		}
+		if n.EscalateMax > 0 {
+			set("escalate_max", strconv.Itoa(n.EscalateMax))
+		}
+		set("escalate_to", n.EscalateTo)
+	}
+	return form
+}
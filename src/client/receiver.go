@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/notifi-backend/lambda-src/sealedbox"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// backlogMessage asks the server to send the queued notifications of the connected device
+const backlogMessage = "."
+
+// Received is a notification received over the websocket
+type Received struct {
+	UUID       string `json:"UUID"`
+	Time       string `json:"time"`
+	Title      string `json:"title"`
+	Message    string `json:"message"`
+	Link       string `json:"link"`
+	Image      string `json:"image"`
+	Topic      string `json:"topic,omitempty"`
+	Escalate   bool   `json:"escalate,omitempty"`
+	Repeats    int    `json:"repeats,omitempty"`
+	DedupeKey  string `json:"dedupe_key,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+// Open decrypts the content of n sealed to the public key of privateKey, if it is end-to-end encrypted
+func (n *Received) Open(privateKey []byte) error {
+	if len(n.Ciphertext) == 0 {
+		return nil
+	}
+	box, err := base64.StdEncoding.DecodeString(n.Ciphertext)
+	if err != nil {
+		return err
+	}
+	content, err := sealedbox.Open(privateKey, box)
+	if err != nil {
+		return err
+	}
+
+	var sealed struct {
+		Title   string `json:"title"`
+		Message string `json:"message"`
+		Image   string `json:"image"`
+		Link    string `json:"link"`
+	}
+	if err := json.Unmarshal(content, &sealed); err != nil {
+		return err
+	}
+	n.Title, n.Message, n.Image, n.Link, n.Ciphertext = sealed.Title, sealed.Message, sealed.Image, sealed.Link, ""
+	return nil
+}
+
+// Receiver connects to the websocket of a notifi backend as a device to receive its notifications
+type Receiver struct {
+	// URL is the websocket url e.g. wss://ws.notifi.it
+	URL string
+
+	// ServerKey is the server key of the client release
+	ServerKey string
+
+	UUID        string
+	Credentials Credentials
+
+	// Version is the version of the client, OS its operating system and PublicKey the base64 X25519 public key
+	// notifications are sealed to, if set
+	Version   string
+	OS        string
+	PublicKey string
+
+	Dialer *websocket.Dialer
+}
+
+// Conn is a websocket connection of a Receiver
+type Conn struct {
+	ws *websocket.Conn
+}
+
+// Connect connects r to the websocket. An Error is returned if the server refuses the connection, with the
+// StatusRequestNewUser code if the device has to Register first.
+func (r *Receiver) Connect(ctx context.Context) (*Conn, error) {
+	dialer := r.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	ws, resp, err := dialer.DialContext(ctx, r.URL, r.header())
+	if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+		return nil, newError(resp, body)
+	} else if err != nil {
+		return nil, err
+	}
+	return &Conn{ws: ws}, nil
+}
+
+// header returns the handshake headers of r. API Gateway passes websocket headers with the case they were sent in and
+// the server looks them up in lower case, so they are not canonicalised.
+func (r *Receiver) header() http.Header {
+	header := http.Header{
+		"sec-key":     {r.ServerKey},
+		"credentials": {r.Credentials.Credentials},
+		"key":         {r.Credentials.Key},
+		"uuid":        {r.UUID},
+		"version":     {r.Version},
+	}
+	if len(r.OS) > 0 {
+		header["os"] = []string{r.OS}
+	}
+	if len(r.PublicKey) > 0 {
+		header["public-key"] = []string{r.PublicKey}
+	}
+	return header
+}
+
+// RequestBacklog asks the server to send the notifications queued while the device was not connected
+func (c *Conn) RequestBacklog() error {
+	return c.ws.WriteMessage(websocket.TextMessage, []byte(backlogMessage))
+}
+
+// Next returns the next notifications sent over the websocket, skipping the other messages the server sends.
+// Blocking reads are stopped by closing c when ctx is done.
+func (c *Conn) Next(ctx context.Context) ([]Received, error) {
+	stop := context.AfterFunc(ctx, func() { _ = c.ws.Close() })
+	defer stop()
+
+	for {
+		_, msg, err := c.ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+		if !strings.HasPrefix(strings.TrimSpace(string(msg)), "[") {
+			// stats and token responses are objects
+			continue
+		}
+
+		var notifications []Received
+		if err := json.Unmarshal(msg, &notifications); err != nil {
+			return nil, err
+		}
+		return notifications, nil
+	}
+}
+
+// Ack acknowledges the notifications uuids were received so they are removed from the queue and not escalated
+func (c *Conn) Ack(uuids ...string) error {
+	if len(uuids) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(uuids)
+	if err != nil {
+		return err
+	}
+	return c.ws.WriteMessage(websocket.TextMessage, b)
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.ws.Close()
+}
+
+// Receive connects r, requests the backlog and calls handle with every notification received until ctx is done or
+// the connection fails. Notifications handle returns nil for are acknowledged.
+func (r *Receiver) Receive(ctx context.Context, handle func(Received) error) error {
+	conn, err := r.Connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.RequestBacklog(); err != nil {
+		return err
+	}
+	for {
+		notifications, err := conn.Next(ctx)
+		if err != nil {
+			return err
+		}
+
+		var handled []string
+		for _, n := range notifications {
+			if err := handle(n); err == nil {
+				handled = append(handled, n.UUID)
+			}
+		}
+		if err := conn.Ack(handled...); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/notifi-backend/lambda-src/sealedbox"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsURL returns the websocket url of server
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func testReceiver(server *httptest.Server) *Receiver {
+	r := &Receiver{
+		ServerKey:   "server",
+		UUID:        "uuid",
+		Credentials: Credentials{Credentials: "credentials", Key: "key"},
+		Version:     "1.0.0",
+	}
+	if server != nil {
+		r.URL = wsURL(server)
+	}
+	return r
+}
+
+func TestReceive(t *testing.T) {
+	acked := make(chan []string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range map[string]string{"Sec-Key": "server", "Credentials": "credentials", "Key": "key", "Uuid": "uuid", "Version": "1.0.0"} {
+			if got := r.Header.Get(name); got != value {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				t.Errorf("got %s %s, wanted %s", name, got, value)
+				return
+			}
+		}
+
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer ws.Close()
+
+		if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != backlogMessage {
+			t.Errorf("got %s %v, wanted the backlog to be requested", msg, err)
+			return
+		}
+		_ = ws.WriteMessage(websocket.TextMessage, []byte(`{"stats": {}}`))
+		_ = ws.WriteMessage(websocket.TextMessage, []byte(`[{"UUID": "a", "title": "one"}, {"UUID": "b", "title": "two"}]`))
+
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var uuids []string
+		if err := json.Unmarshal(msg, &uuids); err != nil {
+			t.Error(err)
+		}
+		acked <- uuids
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var titles []string
+	err := testReceiver(server).Receive(ctx, func(n Received) error {
+		titles = append(titles, n.Title)
+		if n.UUID == "b" {
+			return errors.New("not handled")
+		}
+		return nil
+	})
+	if err == nil {
+		t.Errorf("receive should end with an error when the connection closes")
+	}
+
+	if strings.Join(titles, ",") != "one,two" {
+		t.Errorf("got %v, wanted both notifications", titles)
+	}
+	select {
+	case uuids := <-acked:
+		if strings.Join(uuids, ",") != "a" {
+			t.Errorf("got %v, wanted only the handled notification to be acked", uuids)
+		}
+	default:
+		t.Errorf("nothing was acked")
+	}
+}
+
+func TestConnectRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "No credential key for: uuid", StatusRequestNewUser)
+	}))
+	defer server.Close()
+
+	_, err := testReceiver(server).Connect(context.Background())
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != StatusRequestNewUser {
+		t.Errorf("got %v, wanted %d Error", err, StatusRequestNewUser)
+	}
+	if apiErr != nil && apiErr.Temporary() {
+		t.Errorf("requesting new credentials should not be temporary")
+	}
+}
+
+func TestNextContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		_, _, _ = ws.ReadMessage()
+	}))
+	defer server.Close()
+
+	conn, err := testReceiver(server).Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := conn.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, wanted %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	publicKey, privateKey, err := sealedbox.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	box, err := sealedbox.Seal(publicKey, []byte(`{"title": "title", "message": "message", "link": "https://notifi.it"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := Received{UUID: "uuid", Ciphertext: base64.StdEncoding.EncodeToString(box)}
+	if err := n.Open(privateKey); err != nil {
+		t.Fatal(err)
+	}
+	if n.Title != "title" || n.Message != "message" || n.Link != "https://notifi.it" || len(n.Ciphertext) > 0 {
+		t.Errorf("got %+v, wanted the opened content", n)
+	}
+
+	_, otherKey, _ := sealedbox.GenerateKey()
+	sealed := Received{Ciphertext: base64.StdEncoding.EncodeToString(box)}
+	if err := sealed.Open(otherKey); err == nil {
+		t.Errorf("opening with another key should have errored")
+	}
+}
+
+func TestReceiverHandshakeHeaders(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	handshake := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			handshake <- ""
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		for r := bufio.NewReader(conn); ; {
+			line, err := r.ReadString('\n')
+			if err != nil || line == "\r\n" {
+				break
+			}
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		handshake <- strings.Join(lines, "\n")
+	}()
+
+	r := testReceiver(nil)
+	r.URL, r.OS, r.PublicKey = "ws://"+listener.Addr().String(), "linux", "public"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() { _, _ = r.Connect(ctx) }()
+
+	// the headers as connect_handler.go looks them up in the events of API Gateway, which keeps their case
+	sent := <-handshake
+	for _, header := range []string{"sec-key: server", "credentials: credentials", "key: key", "uuid: uuid", "version: 1.0.0", "os: linux", "public-key: public"} {
+		if !slices.Contains(strings.Split(sent, "\n"), header) {
+			t.Errorf("handshake should have sent %q:\n%s", header, sent)
+		}
+	}
+}
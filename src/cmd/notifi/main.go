@@ -0,0 +1,224 @@
+// Command notifi sends notifications from shell scripts and cron jobs and receives them on machines that can not run
+// the Mac app.
+//
+//	notifi send -t "Backup finished" -m "3.2GB copied" --link https://example.com
+//	df -h | notifi send -t "Disk usage"
+//	notifi receive --sink notify-send
+//
+// The credentials, or a send-only token, and the host of the backend are read from NOTIFI_CREDENTIALS and
+// NOTIFI_HOST, falling back to the same names in the config file at NOTIFI_CONFIG or ~/.config/notifi/config. Requests
+// are signed with the base64 NOTIFI_SIGNING_SECRET if it is set.
+//
+// Notifications that fail validation exit with the code of their reason, see validationExitCodes.
+package main
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/notifi-backend/lambda-src/client"
+	"github.com/notifi-backend/lambda-src/config"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exit codes
+const (
+	exitOK         = 0
+	exitError      = 1
+	exitUsage      = 2
+	exitValidation = 3
+	exitQueued     = 4
+)
+
+// validationExitCodes are the exit codes of notifications that fail validation by reason
+var validationExitCodes = map[string]int{
+	client.ReasonCredentials: 10,
+	client.ReasonTitle:       11,
+	client.ReasonMessage:     12,
+	client.ReasonLink:        13,
+	client.ReasonImage:       14,
+	client.ReasonDedupe:      15,
+	client.ReasonEscalation:  16,
+	client.ReasonSize:        17,
+	client.ReasonCiphertext:  18,
+	client.ReasonTopic:       19,
+}
+
+// waitInterval is how often the status of a queued notification is checked with --wait
+const waitInterval = 2 * time.Second
+
+const usage = `usage: notifi send -t <title> [-m <message>|-] [--link url] [--image url] [--topic topic] [--wait]
+       notifi receive [--sink notify-send,stdout,exec] [--exec command]
+`
+
+func main() {
+	stdin := input{Reader: os.Stdin}
+	if info, err := os.Stdin.Stat(); err == nil {
+		stdin.Piped = piped(info.Mode())
+	}
+	os.Exit(run(os.Args[1:], os.Getenv, stdin, os.Stdout, os.Stderr))
+}
+
+// input is the stdin of the command
+type input struct {
+	io.Reader
+
+	// Piped is set when a pipe or file is redirected to stdin
+	Piped bool
+}
+
+// piped returns whether stdin of mode is a pipe or file, rather than a terminal or whatever cron or a service
+// manager left it as
+func piped(mode os.FileMode) bool {
+	return mode&os.ModeNamedPipe != 0 || mode.IsRegular()
+}
+
+// run runs the command of args and returns its exit code
+func run(args []string, getenv func(string) string, stdin input, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "send":
+			return runSend(args[1:], getenv, stdin, stdout, stderr)
+		case "receive":
+			return runReceive(args[1:], getenv, stdout, stderr)
+		}
+	}
+	_, _ = fmt.Fprint(stderr, usage)
+	return exitUsage
+}
+
+// runSend sends a notification
+func runSend(args []string, getenv func(string) string, stdin input, stdout, stderr io.Writer) int {
+	var (
+		n           client.Notification
+		configPath  string
+		wait        bool
+		timeout     time.Duration
+		credentials string
+	)
+	flags := flag.NewFlagSet("send", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	for _, name := range []string{"t", "title"} {
+		flags.StringVar(&n.Title, name, "", "title of the notification")
+	}
+	for _, name := range []string{"m", "message"} {
+		flags.StringVar(&n.Message, name, "", "message of the notification, - or piped stdin to read it from stdin")
+	}
+	flags.StringVar(&n.Link, "link", "", "url opened when the notification is clicked")
+	flags.StringVar(&n.Image, "image", "", "url of an image shown with the notification")
+	flags.StringVar(&n.Topic, "topic", "", "topic of the notification")
+	flags.StringVar(&credentials, "credentials", "", "credentials to send with instead of NOTIFI_CREDENTIALS")
+	flags.StringVar(&configPath, "config", "", "config file instead of NOTIFI_CONFIG")
+	flags.BoolVar(&wait, "wait", false, "wait for the notification to be delivered and print its status")
+	flags.DurationVar(&timeout, "timeout", time.Minute, "how long to --wait before exiting with the queued status")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	settings, err := loadSettings(configPath, getenv)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "notifi: %s\n", err)
+		return exitUsage
+	}
+	if len(credentials) == 0 {
+		credentials = settings("NOTIFI_CREDENTIALS")
+	}
+	host := settings("NOTIFI_HOST")
+	if len(credentials) == 0 || len(host) == 0 {
+		_, _ = fmt.Fprintln(stderr, "notifi: NOTIFI_CREDENTIALS and NOTIFI_HOST must be set")
+		return exitUsage
+	}
+	n.Credentials = credentials
+
+	if n.Message == "-" || len(n.Message) == 0 && stdin.Piped {
+		if stdin.Reader == nil {
+			_, _ = fmt.Fprintln(stderr, "notifi: no stdin to read the message from")
+			return exitUsage
+		}
+		message, err := io.ReadAll(stdin)
+		if err != nil {
+			_, _ = fmt.Fprintf(stderr, "notifi: %s\n", err)
+			return exitError
+		}
+		n.Message = strings.TrimRight(string(message), "\n")
+	}
+
+	c := client.New(host)
+	if secret := settings("NOTIFI_SIGNING_SECRET"); len(secret) > 0 {
+		if c.SigningSecret, err = b64.StdEncoding.DecodeString(secret); err != nil {
+			_, _ = fmt.Fprintln(stderr, "notifi: NOTIFI_SIGNING_SECRET is not base64")
+			return exitUsage
+		}
+	}
+
+	ctx := context.Background()
+	result, err := c.Send(ctx, &n)
+	if err == nil && wait {
+		waitCtx, cancel := context.WithTimeout(ctx, timeout)
+		result, err = c.Wait(waitCtx, credentials, result, waitInterval)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			_, _ = fmt.Fprintf(stdout, "%s %s\n", result.Status, result.UUID)
+			return exitQueued
+		}
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "notifi: %s\n", err)
+		return exitCode(err)
+	}
+	if wait {
+		_, _ = fmt.Fprintf(stdout, "%s %s\n", result.Status, result.UUID)
+	}
+	return exitOK
+}
+
+// exitCode returns the exit code of a failed send
+func exitCode(err error) int {
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || !apiErr.Validation() {
+		return exitError
+	}
+	if code, ok := validationExitCodes[apiErr.Reason]; ok {
+		return code
+	}
+	return exitValidation
+}
+
+// loadSettings returns a lookup of settings from the environment, falling back to the config file at path,
+// NOTIFI_CONFIG or the default config file if it exists
+func loadSettings(path string, getenv func(string) string) (func(string) string, error) {
+	if len(path) == 0 {
+		path = getenv("NOTIFI_CONFIG")
+	}
+	explicit := len(path) > 0
+	if !explicit {
+		dir, err := os.UserConfigDir()
+		if err == nil {
+			path = filepath.Join(dir, "notifi", "config")
+		}
+	}
+
+	file := map[string]string{}
+	if len(path) > 0 {
+		values, err := config.ReadEnvFile(path)
+		if err != nil && (explicit || !errors.Is(err, os.ErrNotExist)) {
+			return nil, err
+		}
+		if err == nil {
+			file = values
+		}
+	}
+
+	return func(name string) string {
+		if value := getenv(name); len(value) > 0 {
+			return value
+		}
+		return file[name]
+	}, nil
+}
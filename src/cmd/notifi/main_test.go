@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"github.com/notifi-backend/lambda-src/client"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// env returns a getenv of values
+func env(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func sendWith(t *testing.T, server *httptest.Server, stdin input, args ...string) (int, string) {
+	var stdout, stderr bytes.Buffer
+	getenv := env(map[string]string{
+		"NOTIFI_HOST":        server.URL,
+		"NOTIFI_CREDENTIALS": "credentials",
+		"NOTIFI_CONFIG":      filepath.Join(t.TempDir(), "empty"),
+	})
+	if err := os.WriteFile(getenv("NOTIFI_CONFIG"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	code := run(append([]string{"send"}, args...), getenv, stdin, &stdout, &stderr)
+	return code, stdout.String()
+}
+
+func TestSend(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("credentials") != "credentials" || r.FormValue("title") != "title" || r.FormValue("link") != "https://notifi.it" {
+			t.Errorf("got %v", r.Form)
+		}
+		if r.FormValue("message") != "piped" {
+			t.Errorf("got message %s, wanted it read from stdin", r.FormValue("message"))
+		}
+		_, _ = w.Write([]byte(`{"UUID": "uuid", "status": "delivered"}`))
+	}))
+	defer server.Close()
+
+	code, out := sendWith(t, server, input{strings.NewReader("piped\n"), true}, "-t", "title", "--link", "https://notifi.it", "--wait")
+	if code != exitOK || out != "delivered uuid\n" {
+		t.Errorf("got %d %q, wanted delivered", code, out)
+	}
+}
+
+func TestSendMessageFromStdin(t *testing.T) {
+	var message string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		message = r.FormValue("message")
+		_, _ = w.Write([]byte(`{"UUID": "uuid", "status": "delivered"}`))
+	}))
+	defer server.Close()
+
+	// stdin left open by cron or a service manager is not read unless asked to
+	if code, _ := sendWith(t, server, input{Reader: strings.NewReader("unpiped")}, "-t", "title"); code != exitOK || len(message) > 0 {
+		t.Errorf("got %d %q, wanted stdin that is not piped ignored", code, message)
+	}
+	if code, _ := sendWith(t, server, input{Reader: strings.NewReader("typed")}, "-t", "title", "-m", "-"); code != exitOK || message != "typed" {
+		t.Errorf("got %d %q, wanted stdin read with -m -", code, message)
+	}
+}
+
+func TestPiped(t *testing.T) {
+	for _, tt := range []struct {
+		mode os.FileMode
+		want bool
+	}{
+		{os.ModeNamedPipe, true},
+		{0, true},
+		{os.ModeDevice | os.ModeCharDevice, false},
+		{os.ModeSocket, false},
+	} {
+		if got := piped(tt.mode); got != tt.want {
+			t.Errorf("%v: got %t, wanted %t", tt.mode, got, tt.want)
+		}
+	}
+}
+
+func TestSendValidationExitCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(client.ReasonHeader, client.ReasonLink)
+		http.Error(w, "Invalid link!", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	if code, _ := sendWith(t, server, input{}, "-t", "title", "--link", "foo"); code != validationExitCodes[client.ReasonLink] {
+		t.Errorf("got %d, wanted the link exit code %d", code, validationExitCodes[client.ReasonLink])
+	}
+}
+
+func TestSendWaitTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"UUID": "uuid", "status": "queued"}`))
+	}))
+	defer server.Close()
+
+	code, out := sendWith(t, server, input{}, "-t", "title", "--wait", "--timeout", "10ms")
+	if code != exitQueued || out != "queued uuid\n" {
+		t.Errorf("got %d %q, wanted queued", code, out)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	for _, args := range [][]string{{}, {"foo"}, {"send", "--foo"}} {
+		if code := run(args, env(nil), input{}, &stderr, &stderr); code != exitUsage {
+			t.Errorf("%v: got %d, wanted %d", args, code, exitUsage)
+		}
+	}
+}
+
+func TestLoadSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte("NOTIFI_HOST=https://file\nNOTIFI_CREDENTIALS=file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := loadSettings(path, env(map[string]string{"NOTIFI_CREDENTIALS": "env"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings("NOTIFI_CREDENTIALS") != "env" || settings("NOTIFI_HOST") != "https://file" {
+		t.Errorf("got %s %s, wanted the environment to take precedence over the file", settings("NOTIFI_CREDENTIALS"), settings("NOTIFI_HOST"))
+	}
+
+	if _, err := loadSettings(filepath.Join(t.TempDir(), "missing"), env(nil)); err == nil {
+		t.Errorf("missing config file should have errored")
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	b64 "encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/notifi-backend/lambda-src/client"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// receiverVersion is the client version the receiver connects as
+const receiverVersion = "1.0.0"
+
+// delays between reconnecting, doubling from the min to the max
+var (
+	reconnectMin = time.Second
+	reconnectMax = time.Minute
+)
+
+// Sink shows or forwards a received notification. Notifications are only acknowledged once every sink succeeds.
+type Sink interface {
+	Notify(ctx context.Context, n client.Received) error
+}
+
+// NotifySendSink shows notifications on the desktop with notify-send
+type NotifySendSink struct{}
+
+// Notify runs notify-send with the title of n and its message and link as the body
+func (NotifySendSink) Notify(ctx context.Context, n client.Received) error {
+	body := n.Message
+	if len(n.Link) > 0 {
+		body = strings.TrimSpace(body + "\n" + n.Link)
+	}
+	return exec.CommandContext(ctx, "notify-send", "--app-name", "notifi", "--", n.Title, body).Run()
+}
+
+// JSONSink writes a line of json of each notification to W
+type JSONSink struct {
+	W io.Writer
+}
+
+// Notify writes n as a line of json
+func (s JSONSink) Notify(_ context.Context, n client.Received) error {
+	return json.NewEncoder(s.W).Encode(n)
+}
+
+// ExecSink runs Command with sh for each notification, passing it as json on stdin and its fields in NOTIFI_
+// environment variables
+type ExecSink struct {
+	Command string
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// Notify runs the command for n and fails if it exits with an error
+func (s ExecSink) Notify(ctx context.Context, n client.Received) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", s.Command)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Stdout, cmd.Stderr = s.Stdout, s.Stderr
+	cmd.Env = append(os.Environ(),
+		"NOTIFI_UUID="+n.UUID,
+		"NOTIFI_TIME="+n.Time,
+		"NOTIFI_TITLE="+n.Title,
+		"NOTIFI_MESSAGE="+n.Message,
+		"NOTIFI_LINK="+n.Link,
+		"NOTIFI_IMAGE="+n.Image,
+		"NOTIFI_TOPIC="+n.Topic,
+	)
+	return cmd.Run()
+}
+
+// newSinks creates the sinks of the comma separated names
+func newSinks(names, command string, stdout, stderr io.Writer) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "notify-send":
+			sinks = append(sinks, NotifySendSink{})
+		case "stdout":
+			sinks = append(sinks, JSONSink{W: stdout})
+		case "exec":
+			if len(command) == 0 {
+				return nil, errors.New("--exec must be set to use the exec sink")
+			}
+			sinks = append(sinks, ExecSink{Command: command, Stdout: stderr, Stderr: stderr})
+		default:
+			return nil, fmt.Errorf("unknown sink '%s'", name)
+		}
+	}
+	return sinks, nil
+}
+
+// runReceive receives notifications until it is interrupted
+func runReceive(args []string, getenv func(string) string, stdout, stderr io.Writer) int {
+	var sinkNames, command, configPath string
+	flags := flag.NewFlagSet("receive", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&sinkNames, "sink", "notify-send", "comma separated sinks of notify-send, stdout and exec")
+	flags.StringVar(&command, "exec", "", "command the exec sink runs with sh for each notification")
+	flags.StringVar(&configPath, "config", "", "config file instead of NOTIFI_CONFIG")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	sinks, err := newSinks(sinkNames, command, stdout, stderr)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "notifi: %s\n", err)
+		return exitUsage
+	}
+
+	settings, err := loadSettings(configPath, getenv)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "notifi: %s\n", err)
+		return exitUsage
+	}
+	receiver, privateKey, err := newReceiver(settings)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "notifi: %s\n", err)
+		return exitUsage
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := receive(ctx, receiver, privateKey, sinks, stderr); err != nil {
+		_, _ = fmt.Fprintf(stderr, "notifi: %s\n", err)
+		return exitError
+	}
+	return exitOK
+}
+
+// newReceiver creates the Receiver of the device in settings and returns the private key notifications are sealed
+// to, if it is set
+func newReceiver(settings func(string) string) (*client.Receiver, []byte, error) {
+	receiver := &client.Receiver{
+		URL:       settings("NOTIFI_WS_URL"),
+		ServerKey: settings("NOTIFI_SERVER_KEY"),
+		UUID:      settings("NOTIFI_UUID"),
+		Credentials: client.Credentials{
+			Credentials: settings("NOTIFI_CREDENTIALS"),
+			Key:         settings("NOTIFI_CREDENTIAL_KEY"),
+		},
+		Version: receiverVersion,
+		OS:      runtime.GOOS,
+	}
+	for name, value := range map[string]string{
+		"NOTIFI_WS_URL":         receiver.URL,
+		"NOTIFI_SERVER_KEY":     receiver.ServerKey,
+		"NOTIFI_UUID":           receiver.UUID,
+		"NOTIFI_CREDENTIALS":    receiver.Credentials.Credentials,
+		"NOTIFI_CREDENTIAL_KEY": receiver.Credentials.Key,
+	} {
+		if len(value) == 0 {
+			return nil, nil, fmt.Errorf("%s must be set", name)
+		}
+	}
+
+	encoded := settings("NOTIFI_PRIVATE_KEY")
+	if len(encoded) == 0 {
+		return receiver, nil, nil
+	}
+	privateKey, err := b64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, nil, errors.New("NOTIFI_PRIVATE_KEY is not base64")
+	}
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("NOTIFI_PRIVATE_KEY: %w", err)
+	}
+	// notifications are sealed to the public key once the device connects with it
+	receiver.PublicKey = b64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+	return receiver, privateKey, nil
+}
+
+// receive dispatches the notifications of receiver to sinks until ctx is done, reconnecting with backoff when the
+// connection fails. It only stops early if the server refuses the device.
+func receive(ctx context.Context, receiver *client.Receiver, privateKey []byte, sinks []Sink, stderr io.Writer) error {
+	handle := func(n client.Received) error {
+		if len(privateKey) > 0 {
+			if err := n.Open(privateKey); err != nil {
+				_, _ = fmt.Fprintf(stderr, "notifi: unable to open notification %s: %s\n", n.UUID, err)
+				return err
+			}
+		}
+		for _, sink := range sinks {
+			if err := sink.Notify(ctx, n); err != nil {
+				_, _ = fmt.Fprintf(stderr, "notifi: problem dispatching notification %s: %s\n", n.UUID, err)
+				return err
+			}
+		}
+		return nil
+	}
+
+	delay := reconnectMin
+	for {
+		connected := time.Now()
+		err := receiver.Receive(ctx, handle)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var apiErr *client.Error
+		if errors.As(err, &apiErr) && !apiErr.Temporary() {
+			// the device has to be registered again or its settings fixed
+			return err
+		}
+
+		if time.Since(connected) > reconnectMax {
+			// the connection was up for a while so this is a new failure
+			delay = reconnectMin
+		}
+		if apiErr != nil && apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+		_, _ = fmt.Fprintf(stderr, "notifi: %s, reconnecting in %s\n", err, delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, reconnectMax)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/notifi-backend/lambda-src/client"
+	"github.com/notifi-backend/lambda-src/sealedbox"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewSinks(t *testing.T) {
+	sinks, err := newSinks("notify-send,stdout,exec", "true", nil, nil)
+	if err != nil || len(sinks) != 3 {
+		t.Errorf("got %v %v, wanted 3 sinks", sinks, err)
+	}
+	if _, err := newSinks("exec", "", nil, nil); err == nil {
+		t.Errorf("exec sink without a command should have errored")
+	}
+	if _, err := newSinks("foo", "", nil, nil); err == nil {
+		t.Errorf("unknown sink should have errored")
+	}
+}
+
+func TestJSONSink(t *testing.T) {
+	var b bytes.Buffer
+	if err := (JSONSink{W: &b}).Notify(context.Background(), client.Received{UUID: "uuid", Title: "title"}); err != nil {
+		t.Fatal(err)
+	}
+	var n client.Received
+	if err := json.Unmarshal(b.Bytes(), &n); err != nil || n.Title != "title" || !strings.HasSuffix(b.String(), "\n") {
+		t.Errorf("got %q %v, wanted a line of json", b.String(), err)
+	}
+}
+
+func TestExecSink(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	sink := ExecSink{Command: `printf '%s|' "$NOTIFI_TITLE" > ` + out + ` && cat >> ` + out}
+	if err := sink.Notify(context.Background(), client.Received{UUID: "uuid", Title: "title"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	title, body, _ := strings.Cut(string(b), "|")
+	var n client.Received
+	if title != "title" || json.Unmarshal([]byte(body), &n) != nil || n.UUID != "uuid" {
+		t.Errorf("got %q, wanted the title in the environment and the notification on stdin", b)
+	}
+
+	if err := (ExecSink{Command: "exit 1"}).Notify(context.Background(), client.Received{}); err == nil {
+		t.Errorf("failing command should have errored")
+	}
+}
+
+func TestReceiveReconnects(t *testing.T) {
+	reconnectMin, reconnectMax = time.Millisecond, time.Millisecond
+
+	var connects int32
+	acked := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&connects, 1) == 1 {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		if _, msg, err := ws.ReadMessage(); err != nil || string(msg) != "." {
+			t.Errorf("got %s %v, wanted the backlog to be requested", msg, err)
+			return
+		}
+		_ = ws.WriteMessage(websocket.TextMessage, []byte(`[{"UUID": "uuid", "title": "title"}]`))
+		if _, msg, err := ws.ReadMessage(); err == nil {
+			acked <- string(msg)
+		}
+		_, _, _ = ws.ReadMessage()
+	}))
+	defer server.Close()
+
+	receiver := &client.Receiver{URL: "ws" + strings.TrimPrefix(server.URL, "http"), Version: receiverVersion}
+	var stdout, stderr bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- receive(ctx, receiver, nil, []Sink{JSONSink{W: &stdout}}, &stderr)
+	}()
+
+	select {
+	case ack := <-acked:
+		if ack != `["uuid"]` {
+			t.Errorf("got %s, wanted the notification to be acked", ack)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was acked")
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("got %v, wanted receiving to stop without an error", err)
+	}
+	if !strings.Contains(stdout.String(), `"title":"title"`) {
+		t.Errorf("got %q, wanted the notification written to the sink", stdout.String())
+	}
+}
+
+func TestReceiveRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "No credential key", client.StatusRequestNewUser)
+	}))
+	defer server.Close()
+
+	receiver := &client.Receiver{URL: "ws" + strings.TrimPrefix(server.URL, "http")}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := receive(ctx, receiver, nil, nil, &bytes.Buffer{}); err == nil {
+		t.Errorf("refused device should have stopped receiving")
+	}
+}
+
+func TestNewReceiver(t *testing.T) {
+	values := map[string]string{
+		"NOTIFI_WS_URL":         "wss://ws.example.com",
+		"NOTIFI_SERVER_KEY":     "server",
+		"NOTIFI_UUID":           "uuid",
+		"NOTIFI_CREDENTIALS":    "credentials",
+		"NOTIFI_CREDENTIAL_KEY": "key",
+	}
+	receiver, privateKey, err := newReceiver(env(values))
+	if err != nil || receiver.Credentials.Key != "key" || len(receiver.PublicKey) > 0 || privateKey != nil {
+		t.Errorf("got %+v %v, wanted a receiver without a public key", receiver, err)
+	}
+
+	publicKey, key, err := sealedbox.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values["NOTIFI_PRIVATE_KEY"] = base64.StdEncoding.EncodeToString(key)
+	receiver, privateKey, err = newReceiver(env(values))
+	if err != nil || receiver.PublicKey != base64.StdEncoding.EncodeToString(publicKey) || !bytes.Equal(privateKey, key) {
+		t.Errorf("got %+v %v, wanted the public key of NOTIFI_PRIVATE_KEY", receiver, err)
+	}
+
+	delete(values, "NOTIFI_UUID")
+	if _, _, err := newReceiver(env(values)); err == nil {
+		t.Errorf("missing NOTIFI_UUID should have errored")
+	}
+}
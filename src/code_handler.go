@@ -2,13 +2,17 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/notifi-backend/lambda-src/sealedbox"
+	"math"
 	"net/http"
-	"os"
+	"strconv"
 )
 
-func HandleCode(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("Sec-Key") != os.Getenv("SERVER_KEY") {
+func (h *Handlers) HandleCode(w http.ResponseWriter, r *http.Request) {
+	if err := h.VerifyServerKey(r.Context(), "/code", r.Header.Get("Sec-Key")); err != nil {
+		WriteHttpError(w, r, err, http.StatusForbidden)
 		return
 	}
 
@@ -26,6 +30,9 @@ func HandleCode(w http.ResponseWriter, r *http.Request) {
 		// when asking for new Credentials
 		CredentialsKey: r.Form.Get("current_credential_key"),
 		Credentials:    r.Form.Get("current_credentials"),
+
+		// to receive end-to-end encrypted notifications
+		PublicKey: r.Form.Get("public_key"),
 	}
 
 	if !IsValidUUID(PostUser.UUID) {
@@ -33,17 +40,63 @@ func HandleCode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	db, err := GetDB()
-	if err != nil {
-		WriteHttpError(w, r, err, http.StatusInternalServerError)
-		return
+	if len(PostUser.PublicKey) > 0 {
+		if _, err := sealedbox.ParsePublicKey(PostUser.PublicKey); err != nil {
+			WriteHttpError(w, r, err, http.StatusBadRequest)
+			return
+		}
 	}
 
-	creds, err := PostUser.Store(db)
-	if err != nil {
+	ctx := r.Context()
+	failures := failureKeys(h.DB.Hasher, PostUser.UUID, RemoteIP(r))
+	if len(PostUser.CredentialsKey) > 0 {
+		if wait := h.LockedOut(ctx, failures...); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			WriteHttpError(w, r, ErrLockedOut, LockedOutCode)
+			return
+		}
+	}
+
+	creds, err := PostUser.Store(ctx, h.DB)
+	if errors.Is(err, ErrInvalidCredentialKey) {
+		h.RecordFailure(ctx, "/code", failures...)
+		WriteHttpError(w, r, err, http.StatusForbidden)
+		return
+	} else if err != nil {
 		WriteHttpError(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	if len(PostUser.CredentialsKey) > 0 {
+		h.ResetFailures(ctx, failures[0])
+	}
+
+	var migration MigrationStats
+	if len(creds.Revoked) > 0 {
+		// the new credentials are already stored so a failed migration must not stop them being returned
+		migration, err = MigrateCredentials(ctx, h.DB, h.Envelope, creds.Revoked, h.DB.Hasher.Lookup(creds.Value), r.Form.Get("purge_notifications") == "true")
+		if err != nil {
+			Logger(ctx).WithField("err", err.Error()).Error("problem migrating notifications to new credentials")
+		}
+	}
+	AuditCredentialsIssued(ctx, h.DB.Hasher, PostUser.UUID, RemoteIP(r), creds, migration)
+
+	// a signing secret is only rotated when asked for, as senders sign with it. The device can not use the new
+	// credentials without them, so they are returned without a signing secret rather than locking it out when one
+	// can not be issued.
+	require := r.Form.Get("require_signature")
+	if rotate := r.Form.Get("signing_secret") == "true"; rotate || require == "true" {
+		creds.SigningSecret, err = IssueSigningSecret(ctx, h.DB, h.Envelope, PostUser.UUID, rotate)
+		if err != nil {
+			Logger(ctx).WithField("err", err.Error()).Error("problem issuing signing secret")
+			// signatures are not required without a secret to sign with
+			require = ""
+		}
+	}
+	if require == "true" || require == "false" {
+		if err := RequireSignature(ctx, h.DB, PostUser.UUID, require == "true"); err != nil {
+			Logger(ctx).WithField("err", err.Error()).Error("problem updating the signature requirement")
+		}
+	}
 
 	c, err := json.Marshal(creds)
 	if err != nil {
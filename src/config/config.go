@@ -0,0 +1,400 @@
+// Package config loads and validates the configuration of the notifi backend.
+//
+// Every value is read from the environment, falling back to the dotenv style file at CONFIG_FILE. A value can be
+// read from a file instead by setting <NAME>_FILE to its path, which is how secrets should be passed.
+package config
+
+import (
+	"bufio"
+	"crypto/aes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// modes the backend can be run in
+const (
+	ModeServer     = "server"
+	ModeHTTP       = "http"
+	ModeConnect    = "connect"
+	ModeMessage    = "message"
+	ModeDisconnect = "disconnect"
+	ModeEscalate   = "escalate"
+	ModeReencrypt  = "reencrypt"
+	ModeAdmin      = "admin"
+)
+
+// EncryptionKeyLen is the length of the AES-256 ENCRYPTION_KEY
+const EncryptionKeyLen = 32
+
+// DefaultServerKeyID is the id SERVER_KEY is reported under in the metrics of the server keys
+const DefaultServerKeyID = "default"
+
+// MinLookupKeyLen is the minimum length of the LOOKUP_KEY lookup hashes are keyed with
+const MinLookupKeyLen = 32
+
+// DefaultBcryptCost is the cost credential keys are hashed with when BCRYPT_COST is not set
+const DefaultBcryptCost = 10
+
+// password hash algorithms of PASSWORD_HASH
+const (
+	PasswordHashBcrypt   = "bcrypt"
+	PasswordHashArgon2id = "argon2id"
+)
+
+// DefaultEncryptionKeyID is the id of ENCRYPTION_KEY when ENCRYPTION_KEY_ID is not set
+const DefaultEncryptionKeyID = "1"
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Tables structure of the names of the DynamoDB tables. Optional tables disable their feature when empty.
+type Tables struct {
+	User         string
+	Notification string
+	Escalation   string
+	Usage        string
+	Token        string
+}
+
+// Config structure of all the configuration of the backend
+type Config struct {
+	AWSRegion                  string
+	ServerKey                  string
+	AdditionalServerKeys       string
+	EncryptionKey              string
+	EncryptionKeyID            string
+	RetiredEncryptionKeys      string
+	KeyProvider                string
+	KMSKeyID                   string
+	FirebaseCredentialsJSONB64 string
+	WSHost                     string
+	WSEndpoint                 string
+	Port                       string
+	Tables                     Tables
+
+	LookupKey    string
+	PasswordHash string
+	BcryptCost   int
+
+	RateLimitStore string
+	RateLimitTable string
+	RateLimitPlans string
+	RedisHost      string
+}
+
+// Load loads the Config from the environment and the optional CONFIG_FILE
+func Load() (*Config, error) {
+	l := loader{file: map[string]string{}}
+	if path := os.Getenv("CONFIG_FILE"); len(path) > 0 {
+		file, err := ReadEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		l.file = file
+	}
+
+	cfg := &Config{
+		AWSRegion:                  l.get("AWS_REGION"),
+		ServerKey:                  l.get("SERVER_KEY"),
+		AdditionalServerKeys:       l.get("ADDITIONAL_SERVER_KEYS"),
+		EncryptionKey:              l.get("ENCRYPTION_KEY"),
+		EncryptionKeyID:            l.get("ENCRYPTION_KEY_ID"),
+		RetiredEncryptionKeys:      l.get("RETIRED_ENCRYPTION_KEYS"),
+		KeyProvider:                l.get("KEY_PROVIDER"),
+		KMSKeyID:                   l.get("KMS_KEY_ID"),
+		FirebaseCredentialsJSONB64: l.get("FIREBASE_CREDENTIALS_JSON_B64"),
+		WSHost:                     l.get("WS_HOST"),
+		WSEndpoint:                 l.get("WS_ENDPOINT"),
+		Port:                       l.get("PORT"),
+		Tables: Tables{
+			User:         l.get("USER_TABLE_NAME"),
+			Notification: l.get("NOTIFICATION_TABLE_NAME"),
+			Escalation:   l.get("ESCALATION_TABLE_NAME"),
+			Usage:        l.get("USAGE_TABLE_NAME"),
+			Token:        l.get("TOKEN_TABLE_NAME"),
+		},
+		RateLimitStore: l.get("RATE_LIMIT_STORE"),
+		RateLimitTable: l.get("RATE_LIMIT_TABLE_NAME"),
+		RateLimitPlans: l.get("RATE_LIMIT_PLANS"),
+		RedisHost:      l.get("REDIS_HOST"),
+		LookupKey:      l.get("LOOKUP_KEY"),
+		PasswordHash:   l.get("PASSWORD_HASH"),
+		BcryptCost:     l.getInt("BCRYPT_COST", DefaultBcryptCost),
+	}
+	if len(cfg.EncryptionKeyID) == 0 {
+		cfg.EncryptionKeyID = DefaultEncryptionKeyID
+	}
+	return cfg, errors.Join(l.errs...)
+}
+
+// Validate validates c Config has everything needed to run in mode
+func (c *Config) Validate(mode string) error {
+	required, ok := map[string][]string{
+		ModeServer:     {"AWS_REGION", "SERVER_KEY", "ENCRYPTION_KEY", "WS_HOST", "WS_ENDPOINT", "USER_TABLE_NAME", "NOTIFICATION_TABLE_NAME"},
+		ModeHTTP:       {"AWS_REGION", "SERVER_KEY", "ENCRYPTION_KEY", "WS_HOST", "WS_ENDPOINT", "USER_TABLE_NAME", "NOTIFICATION_TABLE_NAME"},
+		ModeConnect:    {"AWS_REGION", "SERVER_KEY", "WS_ENDPOINT", "USER_TABLE_NAME"},
+		ModeMessage:    {"AWS_REGION", "ENCRYPTION_KEY", "WS_ENDPOINT", "USER_TABLE_NAME", "NOTIFICATION_TABLE_NAME"},
+		ModeDisconnect: {"AWS_REGION", "USER_TABLE_NAME"},
+		ModeEscalate:   {"AWS_REGION", "ENCRYPTION_KEY", "WS_ENDPOINT", "USER_TABLE_NAME", "ESCALATION_TABLE_NAME"},
+		ModeReencrypt:  {"AWS_REGION", "ENCRYPTION_KEY", "NOTIFICATION_TABLE_NAME"},
+		ModeAdmin:      {"AWS_REGION", "USER_TABLE_NAME"},
+	}[mode]
+	if !ok {
+		return fmt.Errorf("invalid mode '%s'", mode)
+	}
+
+	errs := []error{c.Require(required...)}
+	if len(c.EncryptionKey) > 0 {
+		errs = append(errs, ValidateEncryptionKey(c.EncryptionKey))
+		_, err := c.EncryptionKeys()
+		errs = append(errs, err)
+	}
+	if len(c.AdditionalServerKeys) > 0 {
+		_, err := c.ServerKeys()
+		errs = append(errs, err)
+	}
+	if len(c.FirebaseCredentialsJSONB64) > 0 {
+		errs = append(errs, ValidateFirebaseCredentials(c.FirebaseCredentialsJSONB64))
+	}
+	if len(c.WSEndpoint) > 0 {
+		errs = append(errs, ValidateWSEndpoint(c.WSEndpoint))
+	}
+
+	if len(c.LookupKey) > 0 {
+		if len(c.LookupKey) < MinLookupKeyLen {
+			errs = append(errs, fmt.Errorf("LOOKUP_KEY must be at least %d bytes not %d", MinLookupKeyLen, len(c.LookupKey)))
+		}
+		if mode == ModeConnect && (len(c.EncryptionKey) == 0 || len(c.Tables.Notification) == 0) {
+			// queued notifications are re-encrypted when the lookup hashes of a user are migrated on connect
+			errs = append(errs, errors.New("ENCRYPTION_KEY and NOTIFICATION_TABLE_NAME must be set to use LOOKUP_KEY"))
+		}
+	}
+
+	switch c.PasswordHash {
+	case "", PasswordHashBcrypt, PasswordHashArgon2id:
+	default:
+		errs = append(errs, fmt.Errorf("unknown PASSWORD_HASH '%s'", c.PasswordHash))
+	}
+	if c.BcryptCost != 0 && (c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost) {
+		errs = append(errs, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+
+	switch c.KeyProvider {
+	case "", "local":
+	case "kms":
+		if len(c.KMSKeyID) == 0 {
+			errs = append(errs, errors.New("KMS_KEY_ID must be set to use the kms key provider"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown KEY_PROVIDER '%s'", c.KeyProvider))
+	}
+
+	switch c.RateLimitStore {
+	case "", "memory":
+	case "dynamo":
+		if len(c.RateLimitTable) == 0 {
+			errs = append(errs, errors.New("RATE_LIMIT_TABLE_NAME must be set to use the dynamo rate limit store"))
+		}
+	case "redis":
+		if len(c.RedisHost) == 0 {
+			errs = append(errs, errors.New("REDIS_HOST must be set to use the redis rate limit store"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown RATE_LIMIT_STORE '%s'", c.RateLimitStore))
+	}
+	return errors.Join(errs...)
+}
+
+// Require returns an error for each of the named settings that is not set
+func (c *Config) Require(names ...string) error {
+	values := map[string]string{
+		"AWS_REGION":              c.AWSRegion,
+		"SERVER_KEY":              c.ServerKey,
+		"ENCRYPTION_KEY":          c.EncryptionKey,
+		"WS_HOST":                 c.WSHost,
+		"WS_ENDPOINT":             c.WSEndpoint,
+		"USER_TABLE_NAME":         c.Tables.User,
+		"NOTIFICATION_TABLE_NAME": c.Tables.Notification,
+		"ESCALATION_TABLE_NAME":   c.Tables.Escalation,
+	}
+
+	var errs []error
+	for _, name := range names {
+		if name == "SERVER_KEY" && len(c.AdditionalServerKeys) > 0 {
+			continue
+		}
+		if len(values[name]) == 0 {
+			errs = append(errs, fmt.Errorf("%s must be set", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// EncryptionKeys returns every encryption key by id. The active ENCRYPTION_KEY is stored under ENCRYPTION_KEY_ID and
+// the retired keys that can still decrypt stored notifications are read from the RETIRED_ENCRYPTION_KEYS json object
+// of id to key.
+func (c *Config) EncryptionKeys() (map[string]string, error) {
+	keys := map[string]string{}
+	if len(c.RetiredEncryptionKeys) > 0 {
+		if err := json.Unmarshal([]byte(c.RetiredEncryptionKeys), &keys); err != nil {
+			return nil, fmt.Errorf("RETIRED_ENCRYPTION_KEYS is not a json object of id to key: %w", err)
+		}
+	}
+	if _, ok := keys[c.EncryptionKeyID]; ok {
+		return nil, fmt.Errorf("ENCRYPTION_KEY_ID '%s' is also a retired key", c.EncryptionKeyID)
+	}
+	keys[c.EncryptionKeyID] = c.EncryptionKey
+
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid encryption key id '%s'", id)
+		}
+		if err := ValidateEncryptionKey(key); err != nil {
+			return nil, fmt.Errorf("encryption key '%s': %w", id, err)
+		}
+	}
+	return keys, nil
+}
+
+// ServerKeys returns every server key clients can send by id. SERVER_KEY is stored under DefaultServerKeyID and the
+// keys of other client releases are read from the ADDITIONAL_SERVER_KEYS json object of id to key, so a new key can
+// be rolled out before the old one is retired.
+func (c *Config) ServerKeys() (map[string]string, error) {
+	keys := map[string]string{}
+	if len(c.AdditionalServerKeys) > 0 {
+		if err := json.Unmarshal([]byte(c.AdditionalServerKeys), &keys); err != nil {
+			return nil, fmt.Errorf("ADDITIONAL_SERVER_KEYS is not a json object of id to key: %w", err)
+		}
+	}
+	if _, ok := keys[DefaultServerKeyID]; ok && len(c.ServerKey) > 0 {
+		return nil, fmt.Errorf("ADDITIONAL_SERVER_KEYS can not use the id '%s' of SERVER_KEY", DefaultServerKeyID)
+	}
+	if len(c.ServerKey) > 0 {
+		keys[DefaultServerKeyID] = c.ServerKey
+	}
+
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid server key id '%s'", id)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("server key '%s' is empty", id)
+		}
+	}
+	return keys, nil
+}
+
+// ValidateEncryptionKey validates key is an AES-256 key
+func ValidateEncryptionKey(key string) error {
+	if len(key) != EncryptionKeyLen {
+		return fmt.Errorf("ENCRYPTION_KEY must be %d bytes not %d", EncryptionKeyLen, len(key))
+	}
+	_, err := aes.NewCipher([]byte(key))
+	return err
+}
+
+// ValidateFirebaseCredentials validates credentialsJsonB64 decodes to json firebase credentials
+func ValidateFirebaseCredentials(credentialsJsonB64 string) error {
+	credentialsJson, err := base64.StdEncoding.DecodeString(credentialsJsonB64)
+	if err != nil {
+		return fmt.Errorf("FIREBASE_CREDENTIALS_JSON_B64 is not base64: %w", err)
+	}
+
+	var credentials struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(credentialsJson, &credentials); err != nil {
+		return fmt.Errorf("FIREBASE_CREDENTIALS_JSON_B64 is not json: %w", err)
+	}
+	if len(credentials.Type) == 0 {
+		return errors.New("FIREBASE_CREDENTIALS_JSON_B64 has no credentials type")
+	}
+	return nil
+}
+
+// ValidateWSEndpoint validates endpoint is a url of the websocket management api
+func ValidateWSEndpoint(endpoint string) error {
+	u, err := url.ParseRequestURI(endpoint)
+	if err != nil {
+		return fmt.Errorf("WS_ENDPOINT: %w", err)
+	}
+	if u.Scheme != "https" || len(u.Host) == 0 {
+		return fmt.Errorf("WS_ENDPOINT '%s' must be an https url", endpoint)
+	}
+	return nil
+}
+
+// loader reads values from the environment and a config file
+type loader struct {
+	file map[string]string
+	errs []error
+}
+
+// get returns the value of name read from the file at <name>_FILE, the environment or the config file
+func (l *loader) get(name string) string {
+	if path, ok := os.LookupEnv(name + "_FILE"); ok {
+		return l.readSecret(name, path)
+	}
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	if path, ok := l.file[name+"_FILE"]; ok {
+		return l.readSecret(name, path)
+	}
+	return l.file[name]
+}
+
+// getInt returns the integer value of name or def if it is not set
+func (l *loader) getInt(name string, def int) int {
+	value := l.get(name)
+	if len(value) == 0 {
+		return def
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s is not an integer: %w", name, err))
+	}
+	return i
+}
+
+func (l *loader) readSecret(name, path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("%s_FILE: %w", name, err))
+		return ""
+	}
+	return strings.TrimRight(string(b), "\r\n")
+}
+
+// ReadEnvFile reads the KEY=VALUE lines of a dotenv style file
+func ReadEnvFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for no := 1; scanner.Scan(); no++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected NAME=VALUE", path, no)
+		}
+		values[strings.TrimSpace(strings.TrimPrefix(name, "export "))] = strings.Trim(strings.TrimSpace(value), `"'`)
+	}
+	return values, scanner.Err()
+}
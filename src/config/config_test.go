@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "server_key")
+	if err := os.WriteFile(secret, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, ".env")
+	contents := "# comment\nUSER_TABLE_NAME=file-user\nWS_HOST=\"ws.notifi.it\"\nSERVER_KEY_FILE=" + secret + "\n"
+	if err := os.WriteFile(file, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("USER_TABLE_NAME", "env-user")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Tables.User != "env-user" {
+		t.Errorf("env should take precedence over the config file, got %s", cfg.Tables.User)
+	}
+	if cfg.WSHost != "ws.notifi.it" {
+		t.Errorf("got %s, wanted value from the config file", cfg.WSHost)
+	}
+	if cfg.ServerKey != "secret" {
+		t.Errorf("got %s, wanted secret read from file", cfg.ServerKey)
+	}
+}
+
+func TestLoadMissingSecret(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, err := Load(); err == nil {
+		t.Errorf("missing secret file should have errored")
+	}
+}
+
+func validConfig() Config {
+	return Config{
+		AWSRegion:       "us-east-1",
+		ServerKey:       "key",
+		EncryptionKey:   strings.Repeat("a", EncryptionKeyLen),
+		EncryptionKeyID: DefaultEncryptionKeyID,
+		WSHost:          "ws.notifi.it",
+		WSEndpoint:      "https://example.com/prod",
+		Tables:          Tables{User: "user", Notification: "notification"},
+	}
+}
+
+var validateTests = []struct {
+	name   string
+	mode   string
+	modify func(c *Config)
+	valid  bool
+}{
+	{"valid", ModeHTTP, func(c *Config) {}, true},
+	{"invalid mode", "foo", func(c *Config) {}, false},
+	{"missing server key", ModeHTTP, func(c *Config) { c.ServerKey = "" }, false},
+	{"short encryption key", ModeHTTP, func(c *Config) { c.EncryptionKey = "short" }, false},
+	{"http ws endpoint", ModeHTTP, func(c *Config) { c.WSEndpoint = "http://example.com" }, false},
+	{"invalid firebase credentials", ModeHTTP, func(c *Config) { c.FirebaseCredentialsJSONB64 = "!" }, false},
+	{"escalate without table", ModeEscalate, func(c *Config) {}, false},
+	{"escalate", ModeEscalate, func(c *Config) { c.Tables.Escalation = "escalation" }, true},
+	{"disconnect", ModeDisconnect, func(c *Config) { c.ServerKey, c.EncryptionKey = "", "" }, true},
+	{"admin", ModeAdmin, func(c *Config) { c.ServerKey, c.EncryptionKey, c.Tables.Notification = "", "", "" }, true},
+	{"admin without user table", ModeAdmin, func(c *Config) { c.Tables.User = "" }, false},
+	{"kms", ModeHTTP, func(c *Config) { c.KeyProvider, c.KMSKeyID = "kms", "alias/notifi" }, true},
+	{"kms without key", ModeHTTP, func(c *Config) { c.KeyProvider = "kms" }, false},
+	{"unknown key provider", ModeHTTP, func(c *Config) { c.KeyProvider = "foo" }, false},
+	{"unknown rate limit store", ModeHTTP, func(c *Config) { c.RateLimitStore = "foo" }, false},
+	{"retired key", ModeHTTP, func(c *Config) { c.RetiredEncryptionKeys = `{"0": "` + strings.Repeat("b", EncryptionKeyLen) + `"}` }, true},
+	{"short retired key", ModeHTTP, func(c *Config) { c.RetiredEncryptionKeys = `{"0": "short"}` }, false},
+	{"retired active key id", ModeHTTP, func(c *Config) { c.RetiredEncryptionKeys = `{"1": "` + strings.Repeat("b", EncryptionKeyLen) + `"}` }, false},
+	{"invalid key id", ModeHTTP, func(c *Config) { c.EncryptionKeyID = "a:b" }, false},
+	{"redis without host", ModeHTTP, func(c *Config) { c.RateLimitStore = "redis" }, false},
+	{"additional server keys", ModeHTTP, func(c *Config) { c.AdditionalServerKeys = `{"v2": "key2"}` }, true},
+	{"only additional server keys", ModeConnect, func(c *Config) { c.ServerKey, c.AdditionalServerKeys = "", `{"v2": "key2"}` }, true},
+	{"invalid additional server keys", ModeHTTP, func(c *Config) { c.AdditionalServerKeys = `["key2"]` }, false},
+	{"additional server key with default id", ModeHTTP, func(c *Config) { c.AdditionalServerKeys = `{"default": "key2"}` }, false},
+	{"empty additional server key", ModeHTTP, func(c *Config) { c.AdditionalServerKeys = `{"v2": ""}` }, false},
+	{"lookup key", ModeHTTP, func(c *Config) { c.LookupKey = strings.Repeat("k", MinLookupKeyLen) }, true},
+	{"short lookup key", ModeHTTP, func(c *Config) { c.LookupKey = "short" }, false},
+	{"connect lookup key without encryption key", ModeConnect, func(c *Config) {
+		c.LookupKey, c.EncryptionKey = strings.Repeat("k", MinLookupKeyLen), ""
+	}, false},
+	{"argon2id", ModeHTTP, func(c *Config) { c.PasswordHash = PasswordHashArgon2id }, true},
+	{"unknown password hash", ModeHTTP, func(c *Config) { c.PasswordHash = "md5" }, false},
+	{"bcrypt cost", ModeHTTP, func(c *Config) { c.BcryptCost = 12 }, true},
+	{"low bcrypt cost", ModeHTTP, func(c *Config) { c.BcryptCost = 1 }, false},
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range validateTests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+			err := cfg.Validate(tt.mode)
+			if (err == nil) != tt.valid {
+				t.Errorf("got %v, wanted valid %v", err, tt.valid)
+			}
+		})
+	}
+}
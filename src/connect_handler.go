@@ -5,17 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/notifi-backend/lambda-src/sealedbox"
 	"github.com/sirupsen/logrus"
+	"math"
 	"net/http"
-	"os"
 	"time"
 )
 
 const RequestNewUserCode = 551
 
-func HandleConnect(_ context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
-	if r.Headers["sec-key"] != os.Getenv("SERVER_KEY") {
-		return WriteError(fmt.Errorf("Invalid server key"), http.StatusForbidden)
+func (h *Handlers) HandleConnect(ctx context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if err := h.VerifyServerKey(ctx, "connect", r.Headers["sec-key"]); err != nil {
+		return WriteError(ctx, err, http.StatusForbidden)
 	}
 
 	user := User{
@@ -26,26 +27,24 @@ func HandleConnect(_ context.Context, r events.APIGatewayWebsocketProxyRequest)
 
 	// validate inputs
 	if !IsValidUUID(user.UUID) {
-		return WriteError(fmt.Errorf("Invalid UUID '%s'", user.UUID), http.StatusBadRequest)
+		return WriteError(ctx, fmt.Errorf("Invalid UUID '%s'", user.UUID), http.StatusBadRequest)
 	} else if !IsValidVersion(r.Headers["version"]) {
-		return WriteError(fmt.Errorf("Invalid Version %v", r.Headers["version"]), http.StatusBadRequest)
+		return WriteError(ctx, fmt.Errorf("Invalid Version %v", r.Headers["version"]), http.StatusBadRequest)
 	} else if !IsValidCredentials(user.Credentials) {
-		return WriteError(fmt.Errorf("Invalid Credentials"), http.StatusForbidden)
+		return WriteError(ctx, fmt.Errorf("Invalid Credentials"), http.StatusForbidden)
 	}
 
-	db, err := GetDB()
-	if err != nil {
-		return WriteError(err, http.StatusInternalServerError)
+	AddLogFields(ctx, logrus.Fields{"uuid": h.DB.Hasher.Lookup(user.UUID)})
+
+	failures := failureKeys(h.DB.Hasher, user.UUID, r.RequestContext.Identity.SourceIP)
+	if wait := h.LockedOut(ctx, failures...); wait > 0 {
+		return WriteError(ctx, fmt.Errorf("%w, try again in %d seconds", ErrLockedOut, int(math.Ceil(wait.Seconds()))), LockedOutCode)
 	}
 
-	var StoredUser User
-	err = db.Table(UserTable).Get("device_uuid", Hash(user.UUID)).One(&StoredUser)
+	StoredUser, err := GetUserByUUID(ctx, h.DB, user.UUID)
 	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"uuid": Hash(user.UUID),
-			"err":  err.Error(),
-		}).Error("Trying to connect without credentials...")
-		return WriteError(err, http.StatusInternalServerError)
+		Logger(ctx).WithField("err", err.Error()).Error("Trying to connect without credentials...")
+		return WriteError(ctx, err, http.StatusInternalServerError)
 	}
 
 	var errorCode, errorMsg = 0, ""
@@ -56,22 +55,26 @@ func HandleConnect(_ context.Context, r events.APIGatewayWebsocketProxyRequest)
 		} else {
 			errorMsg = "No credential key for: " + user.UUID
 		}
-	} else if !user.Verify(StoredUser) {
+	} else if StoredUser.IsRevoked(user.Credentials, h.DB.Hasher) {
+		errorMsg = ErrCredentialsRevoked.Error()
+		errorCode = http.StatusForbidden
+	} else if !user.Verify(StoredUser, h.DB.Hasher) {
+		h.RecordFailure(ctx, "connect", failures...)
 		errorMsg = "Forbidden"
 		errorCode = http.StatusForbidden
 	} else if len(StoredUser.ConnectionID) > 0 {
-		if err := CloseConnection(StoredUser.ConnectionID); err != nil {
-			logrus.WithFields(logrus.Fields{
-				"connection_id": StoredUser.ConnectionID,
-				"uuid":          Hash(user.UUID),
-				"err":           err.Error(),
+		if err := CloseConnection(h.Config, StoredUser.ConnectionID); err != nil {
+			Logger(ctx).WithFields(logrus.Fields{
+				"open_connection_id": StoredUser.ConnectionID,
+				"err":                err.Error(),
 			}).Error("problem closing already open ws connection")
 		}
 	}
 
 	if errorCode != 0 {
-		return WriteError(errors.New(errorMsg), errorCode)
+		return WriteError(ctx, errors.New(errorMsg), errorCode)
 	}
+	h.ResetFailures(ctx, failures[0])
 
 	StoredUser.AppVersion = r.Headers["version"]
 	if firebaseToken, ok := r.Headers["firebase-token"]; ok {
@@ -80,13 +83,29 @@ func HandleConnect(_ context.Context, r events.APIGatewayWebsocketProxyRequest)
 	if operatingSystem, ok := r.Headers["os"]; ok {
 		StoredUser.OS = operatingSystem
 	}
+	if publicKey, ok := r.Headers["public-key"]; ok {
+		if _, err := sealedbox.ParsePublicKey(publicKey); err != nil {
+			return WriteError(ctx, err, http.StatusBadRequest)
+		}
+		StoredUser.PublicKey = publicKey
+	}
+	if h.DB.Hasher.NeedsRehash(StoredUser.CredentialsKey) {
+		// transparently upgrade the credential key hash to the configured PASSWORD_HASH
+		if StoredUser.CredentialsKey, err = h.DB.Hasher.Password(user.CredentialsKey); err != nil {
+			return WriteError(ctx, err, http.StatusInternalServerError)
+		}
+	}
+	if err := MigrateLookupHashes(ctx, h.DB, h.Envelope, &StoredUser, user); err != nil {
+		return WriteError(ctx, err, http.StatusInternalServerError)
+	}
+
 	StoredUser.LastLogin = time.Now()
 	StoredUser.ConnectionID = r.RequestContext.ConnectionID
 
 	// update user info in db
-	err = db.Table(UserTable).Put(StoredUser).Run()
+	err = h.DB.Users().Put(StoredUser).RunWithContext(ctx)
 	if err != nil {
-		return WriteError(err, http.StatusInternalServerError)
+		return WriteError(ctx, err, http.StatusInternalServerError)
 	}
 
 	return WriteEmptySuccess()
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/guregu/dynamo"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// maxCredentialHistory is the number of issued credentials kept in the CredentialHistory of a user
+const maxCredentialHistory = 10
+
+// reasons credentials are issued
+const (
+	IssuedNew     = "new"
+	IssuedRotated = "rotated"
+	IssuedKey     = "key"
+)
+
+// ErrCredentialsRevoked is returned when credentials replaced by newer credentials are used
+var ErrCredentialsRevoked = errors.New("Credentials have been revoked")
+
+// CredentialRecord structure of the hashed credentials issued to a device
+type CredentialRecord struct {
+	Credentials string    `dynamo:"credentials"`
+	Issued      time.Time `dynamo:"issued_dttm"`
+	Revoked     time.Time `dynamo:"revoked_dttm,omitempty"`
+}
+
+// MigrationStats structure of the counts of notifications moved to rotated credentials
+type MigrationStats struct {
+	Migrated int `json:"migrated"`
+	Purged   int `json:"purged"`
+	Failed   int `json:"failed"`
+}
+
+// issueCredentials replaces the credentials of user with the hashed credentials and records them in the
+// CredentialHistory, revoking the credentials they replace
+func (user *User) issueCredentials(credentials string, now time.Time) {
+	if len(user.Credentials) > 0 {
+		revoked := false
+		for i := range user.CredentialHistory {
+			record := &user.CredentialHistory[i]
+			if record.Credentials == user.Credentials && record.Revoked.IsZero() {
+				record.Revoked = now
+				revoked = true
+			}
+		}
+		if !revoked {
+			// credentials issued before the history was kept
+			user.CredentialHistory = append(user.CredentialHistory, CredentialRecord{
+				Credentials: user.Credentials,
+				Issued:      user.Created,
+				Revoked:     now,
+			})
+		}
+	}
+
+	user.Credentials = credentials
+	user.CredentialHistory = append(user.CredentialHistory, CredentialRecord{Credentials: credentials, Issued: now})
+	if len(user.CredentialHistory) > maxCredentialHistory {
+		user.CredentialHistory = user.CredentialHistory[len(user.CredentialHistory)-maxCredentialHistory:]
+	}
+}
+
+// rehashCredentials replaces the credentials of user with the same credentials under another hash. The replaced hash
+// is kept in the CredentialHistory so anything still stored under it is erased with the user.
+func (user *User) rehashCredentials(credentials string) {
+	record := CredentialRecord{Credentials: user.Credentials, Issued: user.Created}
+	recorded := false
+	for _, r := range user.CredentialHistory {
+		if r.Credentials == user.Credentials {
+			record, recorded = r, true
+		}
+	}
+	if !recorded {
+		user.CredentialHistory = append(user.CredentialHistory, record)
+	}
+
+	user.Credentials = credentials
+	user.CredentialHistory = append(user.CredentialHistory, CredentialRecord{Credentials: credentials, Issued: record.Issued})
+}
+
+// IsRevoked returns whether the plain credentials were issued to user and have since been revoked
+func (user User) IsRevoked(credentials string, hasher *Hasher) bool {
+	for _, record := range user.CredentialHistory {
+		if !record.Revoked.IsZero() && hasher.MatchesLookup(record.Credentials, credentials) {
+			return true
+		}
+	}
+	return false
+}
+
+// MigrateCredentials moves the queued notifications, the escalations and the tokens of the hashed credentials from
+// to the hashed credentials to so they are not orphaned when credentials are rotated. The content of notifications
+// is bound to their credentials so they are re-encrypted. If purge is set the notifications and escalations are
+// deleted instead. Notifications and escalations that can not be re-encrypted are left under from and counted as
+// failed.
+func MigrateCredentials(ctx context.Context, db *DB, envelope *Envelope, from, to string, purge bool) (MigrationStats, error) {
+	var stats MigrationStats
+
+	var notifications []Notification
+	err := db.Notifications().Get("credentials", from).Index("credentials-index").AllWithContext(ctx, &notifications)
+	if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+		return stats, err
+	}
+
+	for _, n := range notifications {
+		if purge {
+			err = db.Notifications().Delete("uuid", n.UUID).If("'credentials' = ?", from).RunWithContext(ctx)
+		} else if err = migrateNotification(ctx, &n, envelope, to); err != nil {
+			// a temporary failure must not destroy the notification so it is left under the old credentials
+			stats.Failed++
+			Logger(ctx).WithFields(logrus.Fields{
+				"uuid": n.UUID,
+				"err":  err.Error(),
+			}).Error("problem migrating notification")
+			continue
+		} else {
+			err = db.Notifications().Put(n).If("'credentials' = ?", from).RunWithContext(ctx)
+		}
+		if err != nil && !dynamo.IsCondCheckFailed(err) {
+			return stats, err
+		}
+
+		if purge {
+			stats.Purged++
+		} else {
+			stats.Migrated++
+		}
+	}
+
+	if db.EscalationEnabled() {
+		// escalations of notifications that were delivered over the websocket have no queued notification
+		escalations, err := GetEscalations(ctx, db, from)
+		if err != nil {
+			return stats, err
+		}
+		for _, e := range escalations {
+			if err := migrateEscalation(ctx, db, envelope, e, from, to, purge); err != nil {
+				stats.Failed++
+				Logger(ctx).WithFields(logrus.Fields{
+					"uuid": e.UUID,
+					"err":  err.Error(),
+				}).Error("problem migrating escalation")
+			}
+		}
+	}
+
+	if db.TokensEnabled() {
+		tokens, err := ListTokens(ctx, db, from)
+		if err != nil {
+			return stats, err
+		}
+		for _, t := range tokens {
+			err := db.Tokens().Update("token", t.Hash).Set("credentials", to).If("'credentials' = ?", from).RunWithContext(ctx)
+			if err != nil && !dynamo.IsCondCheckFailed(err) {
+				return stats, err
+			}
+		}
+	}
+	return stats, nil
+}
+
+// migrateNotification re-encrypts the stored n Notification bound to the hashed credentials to
+func migrateNotification(ctx context.Context, n *Notification, envelope *Envelope, to string) error {
+	if err := n.Decrypt(ctx, envelope); err != nil {
+		return err
+	}
+	n.Credentials = to
+	return n.Encrypt(ctx, envelope)
+}
+
+// migrateEscalation moves e Escalation of the hashed credentials from to the hashed credentials to, re-encrypting
+// its notification, or deletes it if purge is set
+func migrateEscalation(ctx context.Context, db *DB, envelope *Envelope, e Escalation, from, to string, purge bool) error {
+	var err error
+	if purge {
+		err = db.Escalations().Delete("uuid", e.UUID).If("'credentials' = ?", from).RunWithContext(ctx)
+	} else {
+		if err := migrateNotification(ctx, &e.Notification, envelope, to); err != nil {
+			return err
+		}
+		err = db.Escalations().
+			Update("uuid", e.UUID).
+			Set("credentials", to).
+			Set("notification", e.Notification).
+			If("'credentials' = ?", from).
+			RunWithContext(ctx)
+	}
+	if err != nil && !dynamo.IsCondCheckFailed(err) {
+		return err
+	}
+	return nil
+}
+
+// AuditCredentialsIssued logs an audit event of the credentials issued to the device uuid
+func AuditCredentialsIssued(ctx context.Context, hasher *Hasher, uuid, remoteIP string, creds Credentials, stats MigrationStats) {
+	var issued string
+	if len(creds.Value) > 0 {
+		issued = hasher.Lookup(creds.Value)
+	}
+	Logger(ctx).WithFields(logrus.Fields{
+		"audit":                  true,
+		"event":                  "credentials_issued",
+		"uuid":                   hasher.Lookup(uuid),
+		"reason":                 creds.Reason,
+		"credentials":            issued,
+		"revoked_credentials":    creds.Revoked,
+		"migrated_notifications": stats.Migrated,
+		"purged_notifications":   stats.Purged,
+		"failed_notifications":   stats.Failed,
+		"remote_ip":              remoteIP,
+	}).Info("issued credentials")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"github.com/notifi-backend/lambda-src/config"
+	"testing"
+	"time"
+)
+
+func TestIssueCredentials(t *testing.T) {
+	hasher := NewHasher(&config.Config{})
+	created := time.Now().Add(-time.Hour)
+	user := User{Credentials: Hash("legacy"), Created: created}
+
+	first := time.Now()
+	user.issueCredentials(Hash("first"), first)
+	if user.Credentials != Hash("first") {
+		t.Fatalf("expected credentials to be replaced got %s", user.Credentials)
+	}
+	if len(user.CredentialHistory) != 2 {
+		t.Fatalf("expected legacy and issued credentials in history got %d", len(user.CredentialHistory))
+	}
+	if legacy := user.CredentialHistory[0]; !legacy.Issued.Equal(created) || !legacy.Revoked.Equal(first) {
+		t.Errorf("expected legacy credentials to be revoked %+v", legacy)
+	}
+
+	second := first.Add(time.Minute)
+	user.issueCredentials(Hash("second"), second)
+	if !user.IsRevoked("legacy", hasher) || !user.IsRevoked("first", hasher) {
+		t.Errorf("expected previous credentials to be revoked")
+	}
+	if user.IsRevoked("second", hasher) || user.IsRevoked("unknown", hasher) {
+		t.Errorf("expected current and unknown credentials not to be revoked")
+	}
+	if !user.CredentialHistory[1].Revoked.Equal(second) {
+		t.Errorf("expected first credentials revoked at %v got %v", second, user.CredentialHistory[1].Revoked)
+	}
+
+	for i := 0; i < maxCredentialHistory*2; i++ {
+		user.issueCredentials(RandomString(credentialLen), time.Now())
+	}
+	if len(user.CredentialHistory) != maxCredentialHistory {
+		t.Errorf("expected history of %d got %d", maxCredentialHistory, len(user.CredentialHistory))
+	}
+}
+
+func TestIssueNewCredentials(t *testing.T) {
+	var user User
+	user.issueCredentials(Hash("first"), time.Now())
+	if len(user.CredentialHistory) != 1 || user.IsRevoked("first", NewHasher(&config.Config{})) {
+		t.Errorf("expected only unrevoked issued credentials in history %+v", user.CredentialHistory)
+	}
+}
+
+func TestRehashCredentials(t *testing.T) {
+	hasher := NewHasher(&config.Config{LookupKey: testLookupKey})
+	issued := time.Now().Add(-time.Hour)
+	user := User{Created: issued.Add(-time.Hour)}
+	user.issueCredentials(Hash("first"), issued)
+
+	user.rehashCredentials(hasher.Lookup("first"))
+	if user.Credentials != hasher.Lookup("first") {
+		t.Fatalf("expected keyed credentials got %s", user.Credentials)
+	}
+	issuedCredentials := user.issuedCredentials()
+	if len(issuedCredentials) != 2 || issuedCredentials[1] != Hash("first") {
+		t.Errorf("expected legacy hash to be kept in history %v", issuedCredentials)
+	}
+	if keyed := user.CredentialHistory[1]; !keyed.Issued.Equal(issued) || !keyed.Revoked.IsZero() {
+		t.Errorf("expected keyed credentials issued at %v got %+v", issued, keyed)
+	}
+
+	user.issueCredentials(hasher.Lookup("second"), time.Now())
+	if !user.IsRevoked("first", hasher) || user.IsRevoked("second", hasher) {
+		t.Errorf("expected only rehashed credentials to be revoked")
+	}
+}
+
+func TestRehashCredentialsWithoutHistory(t *testing.T) {
+	hasher := NewHasher(&config.Config{LookupKey: testLookupKey})
+	created := time.Now()
+	user := User{Credentials: Hash("legacy"), Created: created}
+
+	user.rehashCredentials(hasher.Lookup("legacy"))
+	if len(user.CredentialHistory) != 2 || user.CredentialHistory[0].Credentials != Hash("legacy") {
+		t.Fatalf("expected legacy and keyed credentials in history %+v", user.CredentialHistory)
+	}
+	if user.IsRevoked("legacy", hasher) || !user.CredentialHistory[1].Issued.Equal(created) {
+		t.Errorf("expected unrevoked keyed credentials issued at creation %+v", user.CredentialHistory)
+	}
+}
+
+func TestMigrateNotification(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t, "2")
+
+	n := Notification{UUID: "uuid", Credentials: Hash("old"), Title: "title", Message: "message"}
+	if err := n.Encrypt(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateNotification(ctx, &n, envelope, Hash("new")); err != nil {
+		t.Fatal(err)
+	}
+	if n.Credentials != Hash("new") {
+		t.Errorf("expected new credentials got %s", n.Credentials)
+	}
+
+	if err := n.Decrypt(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if n.Title != "title" || n.Message != "message" {
+		t.Errorf("expected migrated content got %s %s", n.Title, n.Message)
+	}
+}
@@ -6,14 +6,20 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	b64 "encoding/base64"
-	"golang.org/x/crypto/bcrypt"
+	"errors"
+	"fmt"
 	"io"
-	"log"
 	"math/big"
 )
 
 // EncryptAES encrypts a string using AES with a key
 func EncryptAES(str string, key []byte) (string, error) {
+	return EncryptAESWithAAD(str, key, nil)
+}
+
+// EncryptAESWithAAD encrypts a string using AES with a key, authenticating the additional data aad which must be
+// passed to decrypt it
+func EncryptAESWithAAD(str string, key []byte, aad []byte) (string, error) {
 	if len(str) == 0 {
 		return "", nil
 	}
@@ -33,16 +39,25 @@ func EncryptAES(str string, key []byte) (string, error) {
 		return "", err
 	}
 
-	return b64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(str), nil)), nil
+	return b64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(str), aad)), nil
 }
 
 // DecryptAES decrypts a string using AES with a key
 func DecryptAES(str string, key []byte) (string, error) {
+	return DecryptAESWithAAD(str, key, nil)
+}
+
+// DecryptAESWithAAD decrypts a string using AES with a key and the additional data aad it was encrypted with
+func DecryptAESWithAAD(str string, key []byte, aad []byte) (string, error) {
 	if len(str) == 0 {
 		return "", nil
 	}
 
-	encryptedbytes, _ := b64.StdEncoding.DecodeString(str)
+	encryptedbytes, err := b64.StdEncoding.DecodeString(str)
+	if err != nil {
+		return "", fmt.Errorf("ciphertext is not base64: %w", err)
+	}
+
 	c, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -54,12 +69,12 @@ func DecryptAES(str string, key []byte) (string, error) {
 	}
 
 	nonceSize := gcm.NonceSize()
-	if len(encryptedbytes) < nonceSize {
-		return "", err
+	if len(encryptedbytes) < nonceSize+gcm.Overhead() {
+		return "", errors.New("ciphertext is too short")
 	}
 
 	nonce, ciphertext := encryptedbytes[:nonceSize], encryptedbytes[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
 	if err != nil {
 		return "", err
 	}
@@ -92,18 +107,3 @@ func HashWithBytes(str []byte) string {
 	v := sha256.Sum256(str)
 	return b64.StdEncoding.EncodeToString(v[:])
 }
-
-// PassHash hashes a string specifically to be used for passwords
-func PassHash(str string) string {
-	hash, err := bcrypt.GenerateFromPassword([]byte(str), bcrypt.MinCost)
-	if err != nil {
-		log.Println(err.Error())
-	}
-	return string(hash)
-}
-
-// VerifyPassHash verifies that two hashed strings are for the same password string
-func VerifyPassHash(str string, expectedStr string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(str), []byte(expectedStr))
-	return err == nil
-}
@@ -46,16 +46,27 @@ func TestHash(t *testing.T) {
 	}
 }
 
-func TestPassHash(t *testing.T) {
-	passwordStr := RandomString(10)
-	passwordHash := PassHash(passwordStr)
-	passwordHash2 := PassHash(passwordStr)
-
-	if passwordHash == passwordHash2 {
-		t.Errorf("hashed passwords should be different")
+func TestDecryptErrors(t *testing.T) {
+	if _, err := DecryptAES("!", testKey); err == nil {
+		t.Errorf("invalid base64 should have errored")
+	}
+	if _, err := DecryptAES("YWJj", testKey); err == nil {
+		t.Errorf("short ciphertext should have errored")
 	}
+}
 
-	if VerifyPassHash(passwordHash, passwordHash2) {
-		t.Errorf("password should have verified successfully")
+func TestEncryptWithAAD(t *testing.T) {
+	encryptedstr, err := EncryptAESWithAAD(testStr, testKey, []byte("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str, err := DecryptAESWithAAD(encryptedstr, testKey, []byte("a")); err != nil || str != testStr {
+		t.Errorf("got %s %v, wanted %s", str, err, testStr)
+	}
+	if _, err := DecryptAESWithAAD(encryptedstr, testKey, []byte("b")); err == nil {
+		t.Errorf("different additional data should have errored")
+	}
+	if _, err := DecryptAES(encryptedstr, testKey); err == nil {
+		t.Errorf("missing additional data should have errored")
 	}
 }
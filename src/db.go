@@ -2,14 +2,85 @@ package main
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/guregu/dynamo"
-	"os"
+	"github.com/notifi-backend/lambda-src/config"
+	"time"
 )
 
-func GetDB() (*dynamo.DB, error) {
+// DB is a DynamoDB connection to the configured tables
+type DB struct {
+	*dynamo.DB
+	tables config.Tables
+
+	// Hasher hashes the keys rows are looked up by and the credential keys of users
+	Hasher *Hasher
+}
+
+// NewDB connects to DynamoDB in the region of cfg
+func NewDB(cfg *config.Config) *DB {
 	sesh := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
-	return dynamo.New(sesh, &aws.Config{Region: aws.String(os.Getenv("AWS_REGION"))}), nil
+	traceDynamoRequests(&sesh.Handlers)
+	sesh.Handlers.Complete.PushBack(func(r *request.Request) {
+		dynamoDuration.Observe(time.Since(r.Time).Seconds(), r.Operation.Name)
+	})
+	return &DB{
+		DB:     dynamo.New(sesh, &aws.Config{Region: aws.String(cfg.AWSRegion)}),
+		tables: cfg.Tables,
+		Hasher: NewHasher(cfg),
+	}
+}
+
+// Users returns the user table
+func (db *DB) Users() dynamo.Table {
+	return db.Table(db.tables.User)
+}
+
+// Notifications returns the notification table
+func (db *DB) Notifications() dynamo.Table {
+	return db.Table(db.tables.Notification)
+}
+
+// Escalations returns the escalation table
+func (db *DB) Escalations() dynamo.Table {
+	return db.Table(db.tables.Escalation)
+}
+
+// Usage returns the usage table
+func (db *DB) Usage() dynamo.Table {
+	return db.Table(db.tables.Usage)
+}
+
+// Tokens returns the token table
+func (db *DB) Tokens() dynamo.Table {
+	return db.Table(db.tables.Token)
+}
+
+// EscalationEnabled returns whether an escalation table is configured
+func (db *DB) EscalationEnabled() bool {
+	return len(db.tables.Escalation) > 0
+}
+
+// UsageEnabled returns whether a usage table is configured
+func (db *DB) UsageEnabled() bool {
+	return len(db.tables.Usage) > 0
+}
+
+// TokensEnabled returns whether a token table is configured
+func (db *DB) TokensEnabled() bool {
+	return len(db.tables.Token) > 0
+}
+
+// configuredTables returns the names of all the configured tables
+func (db *DB) configuredTables() []string {
+	var tables []string
+	for _, table := range []string{db.tables.User, db.tables.Notification, db.tables.Escalation, db.tables.Usage, db.tables.Token} {
+		if len(table) > 0 {
+			tables = append(tables, table)
+		}
+	}
+	return tables
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/guregu/dynamo"
+	"github.com/notifi-backend/lambda-src/config"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"time"
+)
+
+// DedupeStore stores the notification last sent under each dedupe key, whether it was delivered or queued
+type DedupeStore interface {
+	// Dedupe records a notification uuid sent under key for window. If a notification was already sent under key
+	// within window it returns the uuid of that notification and how many times it has been repeated, otherwise uuid
+	// and 0.
+	Dedupe(ctx context.Context, key, uuid string, window time.Duration) (string, int, error)
+}
+
+// dedupeRecord is the notification sent under a dedupe key
+type dedupeRecord struct {
+	UUID    string
+	Count   int
+	Expires time.Time
+}
+
+// MemoryDedupeStore keeps dedupe records in memory, so they are only enforced per instance
+type MemoryDedupeStore struct {
+	mu      sync.Mutex
+	records map[string]*dedupeRecord
+}
+
+// NewMemoryDedupeStore creates an empty MemoryDedupeStore
+func NewMemoryDedupeStore() *MemoryDedupeStore {
+	return &MemoryDedupeStore{records: map[string]*dedupeRecord{}}
+}
+
+// Dedupe records uuid as sent under key
+func (s *MemoryDedupeStore) Dedupe(_ context.Context, key, uuid string, window time.Duration) (string, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.records) >= maxMemoryBuckets {
+		for k, r := range s.records {
+			if now.After(r.Expires) {
+				delete(s.records, k)
+			}
+		}
+	}
+
+	r, ok := s.records[key]
+	if !ok || now.After(r.Expires) {
+		r = &dedupeRecord{UUID: uuid}
+		s.records[key] = r
+	}
+	r.Count++
+	r.Expires = now.Add(window)
+	return r.UUID, r.Count - 1, nil
+}
+
+// dedupeItem is a dedupe record stored in DynamoDB
+type dedupeItem struct {
+	Key     string    `dynamo:"key,hash"`
+	UUID    string    `dynamo:"uuid"`
+	Count   int       `dynamo:"count"`
+	Expires time.Time `dynamo:"expires,unixtime"`
+}
+
+// DynamoDedupeStore keeps dedupe records in the DynamoDB rate limit table
+type DynamoDedupeStore struct {
+	db    *dynamo.DB
+	table string
+}
+
+// Dedupe records uuid as sent under key
+func (s *DynamoDedupeStore) Dedupe(ctx context.Context, key, uuid string, window time.Duration) (string, int, error) {
+	table := s.db.Table(s.table)
+	now := time.Now().UTC()
+
+	var item dedupeItem
+	err := table.Update("key", "dedupe:"+key).
+		Add("count", 1).
+		Set("expires", now.Add(window).Unix()).
+		If("attribute_exists('key') AND 'expires' > ?", now.Unix()).
+		ValueWithContext(ctx, &item)
+	if dynamo.IsCondCheckFailed(err) {
+		// nothing has been sent under key within the window, expired items are only removed eventually
+		item = dedupeItem{Key: "dedupe:" + key, UUID: uuid, Count: 1, Expires: now.Add(window)}
+		err = table.Put(item).RunWithContext(ctx)
+	}
+	if err != nil {
+		return uuid, 0, err
+	}
+	return item.UUID, item.Count - 1, nil
+}
+
+// RedisDedupeStore keeps dedupe records in redis
+type RedisDedupeStore struct {
+	client *redis.Client
+}
+
+// Dedupe records uuid as sent under key
+func (s *RedisDedupeStore) Dedupe(ctx context.Context, key, uuid string, window time.Duration) (string, int, error) {
+	var count *redis.IntCmd
+	var sent *redis.StringCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSetNX(ctx, "dedupe:"+key, "uuid", uuid)
+		count = pipe.HIncrBy(ctx, "dedupe:"+key, "count", 1)
+		pipe.PExpire(ctx, "dedupe:"+key, window)
+		sent = pipe.HGet(ctx, "dedupe:"+key, "uuid")
+		return nil
+	})
+	if err != nil {
+		return uuid, 0, err
+	}
+	return sent.Val(), int(count.Val()) - 1, nil
+}
+
+// NewDedupeStore creates the DedupeStore kept in the RATE_LIMIT_STORE (memory, dynamo or redis)
+func NewDedupeStore(cfg *config.Config, db *DB) (DedupeStore, error) {
+	switch cfg.RateLimitStore {
+	case "", "memory":
+		return NewMemoryDedupeStore(), nil
+	case "dynamo":
+		return &DynamoDedupeStore{db: db.DB, table: cfg.RateLimitTable}, nil
+	case "redis":
+		return &RedisDedupeStore{client: redis.NewClient(&redis.Options{
+			Addr: cfg.RedisHost,
+		})}, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit store '%s'", cfg.RateLimitStore)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupeStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryDedupeStore()
+
+	if uuid, repeats, _ := s.Dedupe(ctx, "key", "a", time.Minute); uuid != "a" || repeats != 0 {
+		t.Errorf("got %s repeated %d times, wanted first notification a", uuid, repeats)
+	}
+	for i := 1; i <= 2; i++ {
+		if uuid, repeats, _ := s.Dedupe(ctx, "key", "b", time.Minute); uuid != "a" || repeats != i {
+			t.Errorf("got %s repeated %d times, wanted a repeated %d times", uuid, repeats, i)
+		}
+	}
+	if uuid, repeats, _ := s.Dedupe(ctx, "other", "c", time.Minute); uuid != "c" || repeats != 0 {
+		t.Errorf("got %s repeated %d times for other key, wanted c", uuid, repeats)
+	}
+
+	s.records["key"].Expires = time.Now().Add(-time.Second)
+	if uuid, repeats, _ := s.Dedupe(ctx, "key", "d", time.Minute); uuid != "d" || repeats != 0 {
+		t.Errorf("got %s repeated %d times after the window, wanted d", uuid, repeats)
+	}
+}
+
+func TestDeduplicate(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupeStore()
+
+	first := Notification{UUID: "a", Credentials: "credentials", DedupeKey: "disk-full"}
+	if err := first.Deduplicate(ctx, store); err != nil || first.UUID != "a" || first.Repeats != 0 {
+		t.Fatalf("got %+v %v, wanted first notification kept", first, err)
+	}
+
+	repeat := Notification{UUID: "b", Credentials: "credentials", DedupeKey: "disk-full"}
+	if err := repeat.Deduplicate(ctx, store); err != nil || repeat.UUID != "a" || repeat.Repeats != 1 {
+		t.Errorf("got %+v %v, wanted repeat to take the place of a", repeat, err)
+	}
+
+	other := Notification{UUID: "c", Credentials: "other", DedupeKey: "disk-full"}
+	if err := other.Deduplicate(ctx, store); err != nil || other.UUID != "c" || other.Repeats != 0 {
+		t.Errorf("got %+v %v, wanted dedupe keys of other credentials kept apart", other, err)
+	}
+
+	unkeyed := Notification{UUID: "d", Credentials: "credentials"}
+	if err := unkeyed.Deduplicate(ctx, nil); err != nil || unkeyed.UUID != "d" {
+		t.Errorf("got %+v %v, wanted notification without a dedupe key untouched", unkeyed, err)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// HandleDelete deletes the device authenticated by its UUID, credentials and credential_key along with everything
+// stored for it and returns a DeletionReceipt
+func (h *Handlers) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.AuthenticateDevice(w, r)
+	if !ok {
+		return
+	}
+
+	receipt, err := DeleteUser(r.Context(), h.Config, h.DB, user)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	b, err := json.Marshal(receipt)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	"github.com/notifi-backend/lambda-src/config"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// DeletionReceipt structure of the record of everything deleted for a user
+type DeletionReceipt struct {
+	ID            string    `json:"receipt_id"`
+	UUID          string    `json:"uuid"`
+	Deleted       time.Time `json:"deleted_at"`
+	Notifications int       `json:"notifications"`
+	Escalations   int       `json:"escalations"`
+	Tokens        int       `json:"tokens"`
+	Usage         int       `json:"usage"`
+}
+
+// DeleteUser deletes user, including its push token and signing key, along with the queued notifications, the
+// escalations, the tokens and the usage of every credentials it has been issued
+func DeleteUser(ctx context.Context, cfg *config.Config, db *DB, user User) (DeletionReceipt, error) {
+	receipt := DeletionReceipt{ID: uuid.New().String(), UUID: user.UUID}
+
+	for _, credentials := range user.issuedCredentials() {
+		var notifications []Notification
+		err := db.Notifications().Get("credentials", credentials).Index("credentials-index").AllWithContext(ctx, &notifications)
+		if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+			return receipt, err
+		}
+		for _, n := range notifications {
+			if err := db.Notifications().Delete("uuid", n.UUID).RunWithContext(ctx); err != nil {
+				return receipt, err
+			}
+			receipt.Notifications++
+		}
+
+		if db.EscalationEnabled() {
+			// escalations of delivered notifications have no queued notification but still hold its content
+			escalations, err := GetEscalations(ctx, db, credentials)
+			if err != nil {
+				return receipt, err
+			}
+			for _, e := range escalations {
+				err := db.Escalations().Delete("uuid", e.UUID).If("'credentials' = ?", credentials).RunWithContext(ctx)
+				if err == nil {
+					receipt.Escalations++
+				} else if !dynamo.IsCondCheckFailed(err) {
+					return receipt, err
+				}
+			}
+		}
+
+		if db.TokensEnabled() {
+			var tokens []Token
+			err := db.Tokens().Get("credentials", credentials).Index("credentials-index").AllWithContext(ctx, &tokens)
+			if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+				return receipt, err
+			}
+			for _, t := range tokens {
+				if err := db.Tokens().Delete("token", t.Hash).RunWithContext(ctx); err != nil {
+					return receipt, err
+				}
+				receipt.Tokens++
+			}
+		}
+
+		if db.UsageEnabled() {
+			var usage []Usage
+			err := db.Usage().Get("credentials", credentials).AllWithContext(ctx, &usage)
+			if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+				return receipt, err
+			}
+			for _, u := range usage {
+				if err := db.Usage().Delete("credentials", credentials).Range("period", u.Period).RunWithContext(ctx); err != nil {
+					return receipt, err
+				}
+				receipt.Usage++
+			}
+		}
+	}
+
+	if len(user.ConnectionID) > 0 {
+		if err := CloseConnection(cfg, user.ConnectionID); err != nil {
+			Logger(ctx).WithField("err", err.Error()).Warn("problem closing the connection of a deleted user")
+		}
+	}
+
+	if err := db.Users().Delete("device_uuid", user.UUID).RunWithContext(ctx); err != nil {
+		return receipt, err
+	}
+	receipt.Deleted = time.Now().UTC()
+
+	Logger(ctx).WithFields(logrus.Fields{
+		"audit":         true,
+		"event":         "user_deleted",
+		"receipt_id":    receipt.ID,
+		"uuid":          receipt.UUID,
+		"notifications": receipt.Notifications,
+		"escalations":   receipt.Escalations,
+		"tokens":        receipt.Tokens,
+		"usage":         receipt.Usage,
+	}).Info("deleted user")
+	return receipt, nil
+}
+
+// issuedCredentials returns the hashes of the current and previous credentials of user
+func (user User) issuedCredentials() []string {
+	var credentials []string
+	seen := map[string]bool{}
+	add := func(c string) {
+		if len(c) > 0 && !seen[c] {
+			seen[c] = true
+			credentials = append(credentials, c)
+		}
+	}
+
+	add(user.Credentials)
+	for _, record := range user.CredentialHistory {
+		add(record.Credentials)
+	}
+	return credentials
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIssuedCredentials(t *testing.T) {
+	user := User{Credentials: "c", CredentialHistory: []CredentialRecord{{Credentials: "a"}, {Credentials: "b"}, {Credentials: "c"}}}
+	got := user.issuedCredentials()
+	if strings.Join(got, ",") != "c,a,b" {
+		t.Errorf("got %v, wanted current then previous credentials", got)
+	}
+
+	if got := (User{}).issuedCredentials(); len(got) != 0 {
+		t.Errorf("got %v, wanted no credentials", got)
+	}
+}
+
+func TestAuthenticateDeviceInvalid(t *testing.T) {
+	h := Handlers{}
+	for _, form := range []url.Values{
+		{},
+		{"UUID": {"foo"}, "credentials": {RandomString(credentialLen)}, "credential_key": {"key"}},
+		{"UUID": {"ad5fb4d7-07a8-4b1a-8a4f-10c4b4ba1a5c"}, "credentials": {"short"}, "credential_key": {"key"}},
+		{"UUID": {"ad5fb4d7-07a8-4b1a-8a4f-10c4b4ba1a5c"}, "credentials": {RandomString(credentialLen)}},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/delete", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if _, ok := h.AuthenticateDevice(w, r); ok || w.Code != http.StatusForbidden {
+			t.Errorf("%v: got %d, wanted %d", form, w.Code, http.StatusForbidden)
+		}
+	}
+}
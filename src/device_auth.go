@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"github.com/guregu/dynamo"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// ErrDeviceAuth is returned when a request is not authenticated by the uuid, credentials and credential key of a device
+var ErrDeviceAuth = errors.New("Invalid device credentials")
+
+// AuthenticateDevice returns the user of the device authenticated by the UUID, credentials and credential_key form
+// values of r. Failed attempts are locked out like those made when connecting. On failure the error response has
+// already been written.
+func (h *Handlers) AuthenticateDevice(w http.ResponseWriter, r *http.Request) (User, bool) {
+	ctx := r.Context()
+	if err := r.ParseForm(); err != nil {
+		WriteHttpError(w, r, err, http.StatusBadRequest)
+		return User{}, false
+	}
+
+	device := User{
+		UUID:           r.Form.Get("UUID"),
+		Credentials:    r.Form.Get("credentials"),
+		CredentialsKey: r.Form.Get("credential_key"),
+	}
+	if !IsValidUUID(device.UUID) || !IsValidCredentials(device.Credentials) || len(device.CredentialsKey) == 0 {
+		WriteHttpError(w, r, ErrDeviceAuth, http.StatusForbidden)
+		return User{}, false
+	}
+
+	failures := failureKeys(h.DB.Hasher, device.UUID, RemoteIP(r))
+	if wait := h.LockedOut(ctx, failures...); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		WriteHttpError(w, r, ErrLockedOut, LockedOutCode)
+		return User{}, false
+	}
+
+	user, err := GetUserByUUID(ctx, h.DB, device.UUID)
+	if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return User{}, false
+	}
+	if err != nil || !h.DB.Hasher.MatchesLookup(user.Credentials, device.Credentials) || !device.Verify(user, h.DB.Hasher) {
+		h.RecordFailure(ctx, r.URL.Path, failures...)
+		WriteHttpError(w, r, ErrDeviceAuth, http.StatusForbidden)
+		return User{}, false
+	}
+	h.ResetFailures(ctx, failures[0])
+	return user, true
+}
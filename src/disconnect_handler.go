@@ -3,26 +3,24 @@ package main
 import (
 	"context"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
 	"net/http"
 )
 
-func HandleDisconnect(_ context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
-	db, err := GetDB()
-	if err != nil {
-		return WriteError(err, http.StatusInternalServerError)
-	}
-
+func (h *Handlers) HandleDisconnect(ctx context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// get user UUID from connection
 	var user User
-	err = db.Table(UserTable).Get("connection_id", r.RequestContext.ConnectionID).Index("connection_id-index").One(&user)
+	err := h.DB.Users().Get("connection_id", r.RequestContext.ConnectionID).Index("connection_id-index").OneWithContext(ctx, &user)
 	if err == nil {
+		AddLogFields(ctx, logrus.Fields{"uuid": user.UUID})
+
 		// remove users connection_id field
-		err = db.Table(UserTable).
+		err = h.DB.Users().
 			Update("device_uuid", user.UUID).
 			Remove("connection_id").
-			Run()
+			RunWithContext(ctx)
 		if err != nil {
-			return WriteError(err, http.StatusInternalServerError)
+			return WriteError(ctx, err, http.StatusInternalServerError)
 		}
 	}
 	return WriteEmptySuccess()
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	b64 "encoding/base64"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/notifi-backend/lambda-src/config"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dataKeyLen is the length of the AES-256 data key each notification is encrypted with
+const dataKeyLen = 32
+
+// KeyProvider wraps the data keys notifications are encrypted with using a master key that is never stored with them
+type KeyProvider interface {
+	// Name identifies the provider that wrapped a data key
+	Name() string
+	// WrapKey encrypts key with the master key
+	WrapKey(ctx context.Context, key []byte) (string, error)
+	// UnwrapKey decrypts a key wrapped by WrapKey
+	UnwrapKey(ctx context.Context, wrapped string) ([]byte, error)
+	// NeedsRewrap returns whether wrapped was not wrapped with the current master key
+	NeedsRewrap(wrapped string) bool
+}
+
+// LocalKeyProvider wraps data keys with the active key of a Keyring read from the env or a file
+type LocalKeyProvider struct {
+	Keyring *Keyring
+}
+
+// Name identifies the LocalKeyProvider
+func (p *LocalKeyProvider) Name() string {
+	return "local"
+}
+
+// WrapKey encrypts key with the active key of the Keyring
+func (p *LocalKeyProvider) WrapKey(_ context.Context, key []byte) (string, error) {
+	return p.Keyring.Encrypt(b64.StdEncoding.EncodeToString(key))
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey
+func (p *LocalKeyProvider) UnwrapKey(_ context.Context, wrapped string) ([]byte, error) {
+	key, err := p.Keyring.Decrypt(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return b64.StdEncoding.DecodeString(key)
+}
+
+// NeedsRewrap returns whether wrapped was not wrapped with the active key of the Keyring
+func (p *LocalKeyProvider) NeedsRewrap(wrapped string) bool {
+	return p.Keyring.NeedsReencrypt(wrapped)
+}
+
+// unwrapped data keys are cached by the KMSKeyProvider so a backlog does not make a KMS call per notification
+const (
+	kmsKeyCacheTTL       = 5 * time.Minute
+	maxKMSKeyCacheLength = 4096
+)
+
+// cachedKey is a data key unwrapped by KMS
+type cachedKey struct {
+	key     []byte
+	expires time.Time
+}
+
+// KMSKeyProvider wraps data keys with an AWS KMS key
+type KMSKeyProvider struct {
+	client kmsiface.KMSAPI
+	keyID  string
+
+	mu    sync.Mutex
+	cache map[string]cachedKey
+}
+
+// NewKMSKeyProvider creates a KMSKeyProvider of the KMS key KMS_KEY_ID
+func NewKMSKeyProvider(cfg *config.Config) *KMSKeyProvider {
+	sesh := session.Must(session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            aws.Config{Region: aws.String(cfg.AWSRegion)},
+	}))
+	return &KMSKeyProvider{client: kms.New(sesh), keyID: cfg.KMSKeyID, cache: map[string]cachedKey{}}
+}
+
+// Name identifies the KMSKeyProvider
+func (p *KMSKeyProvider) Name() string {
+	return "kms"
+}
+
+// WrapKey encrypts key with the KMS key
+func (p *KMSKeyProvider) WrapKey(ctx context.Context, key []byte) (string, error) {
+	out, err := p.client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(p.keyID),
+		Plaintext: key,
+	})
+	if err != nil {
+		return "", err
+	}
+	return b64.StdEncoding.EncodeToString(out.CiphertextBlob), nil
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey, caching it for kmsKeyCacheTTL
+func (p *KMSKeyProvider) UnwrapKey(ctx context.Context, wrapped string) ([]byte, error) {
+	if key, ok := p.cached(wrapped); ok {
+		return key, nil
+	}
+
+	blob, err := b64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := p.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(p.keyID),
+		CiphertextBlob: blob,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.store(wrapped, out.Plaintext)
+	return out.Plaintext, nil
+}
+
+// cached returns a copy of the unexpired data key cached for wrapped
+func (p *KMSKeyProvider) cached(wrapped string) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c, ok := p.cache[wrapped]
+	if !ok || time.Now().After(c.expires) {
+		return nil, false
+	}
+	return append([]byte(nil), c.key...), true
+}
+
+// store caches the data key of wrapped, removing the expired keys, or every key if none have expired, when the cache
+// is full
+func (p *KMSKeyProvider) store(wrapped string, key []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if p.cache == nil {
+		p.cache = map[string]cachedKey{}
+	}
+	if len(p.cache) >= maxKMSKeyCacheLength {
+		for k, c := range p.cache {
+			if now.After(c.expires) {
+				delete(p.cache, k)
+			}
+		}
+		if len(p.cache) >= maxKMSKeyCacheLength {
+			clear(p.cache)
+		}
+	}
+	p.cache[wrapped] = cachedKey{key: append([]byte(nil), key...), expires: now.Add(kmsKeyCacheTTL)}
+}
+
+// NeedsRewrap is always false as KMS rotates the key material of a key itself
+func (p *KMSKeyProvider) NeedsRewrap(_ string) bool {
+	return false
+}
+
+// Envelope creates the data keys notifications are encrypted with, wrapped by the master key of the active
+// KeyProvider. Data keys wrapped by the LocalKeyProvider can always be unwrapped so notifications stored before
+// changing KEY_PROVIDER can still be read.
+type Envelope struct {
+	// Keyring decrypts notifications stored before envelope encryption
+	Keyring *Keyring
+
+	provider  KeyProvider
+	providers map[string]KeyProvider
+}
+
+// NewEnvelope creates an Envelope wrapping data keys with provider
+func NewEnvelope(provider KeyProvider, keyring *Keyring) *Envelope {
+	local := &LocalKeyProvider{Keyring: keyring}
+	return &Envelope{
+		Keyring:  keyring,
+		provider: provider,
+		providers: map[string]KeyProvider{
+			local.Name():    local,
+			provider.Name(): provider,
+		},
+	}
+}
+
+// NewDataKey generates a data key and returns it with its wrapped form to be stored
+func (e *Envelope) NewDataKey(ctx context.Context) (key []byte, wrapped string, err error) {
+	key = make([]byte, dataKeyLen)
+	if _, err = io.ReadFull(rand.Reader, key); err != nil {
+		return nil, "", err
+	}
+
+	wrapped, err = e.wrap(ctx, key)
+	return key, wrapped, err
+}
+
+// DataKey unwraps a data key returned by NewDataKey
+func (e *Envelope) DataKey(ctx context.Context, wrapped string) ([]byte, error) {
+	name, wrappedKey, _ := strings.Cut(wrapped, ":")
+	provider, ok := e.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unable to unwrap data key of key provider '%s'", name)
+	}
+
+	key, err := provider.UnwrapKey(ctx, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unwrap data key: %w", err)
+	}
+	return key, nil
+}
+
+// NeedsRewrap returns whether wrapped was not wrapped with the current master key of the active KeyProvider
+func (e *Envelope) NeedsRewrap(wrapped string) bool {
+	name, wrappedKey, _ := strings.Cut(wrapped, ":")
+	return name != e.provider.Name() || e.provider.NeedsRewrap(wrappedKey)
+}
+
+// Rewrap wraps the data key of wrapped with the current master key of the active KeyProvider
+func (e *Envelope) Rewrap(ctx context.Context, wrapped string) (string, error) {
+	key, err := e.DataKey(ctx, wrapped)
+	if err != nil {
+		return "", err
+	}
+	return e.wrap(ctx, key)
+}
+
+func (e *Envelope) wrap(ctx context.Context, key []byte) (string, error) {
+	wrapped, err := e.provider.WrapKey(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("unable to wrap data key: %w", err)
+	}
+	return e.provider.Name() + ":" + wrapped, nil
+}
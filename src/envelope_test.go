@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	b64 "encoding/base64"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"testing"
+	"time"
+)
+
+// reverseKeyProvider is a KeyProvider standing in for a remote master key
+type reverseKeyProvider struct{}
+
+func (p reverseKeyProvider) Name() string { return "reverse" }
+
+func (p reverseKeyProvider) WrapKey(_ context.Context, key []byte) (string, error) {
+	wrapped := make([]byte, len(key))
+	for i := range key {
+		wrapped[len(key)-1-i] = key[i]
+	}
+	return string(wrapped), nil
+}
+
+func (p reverseKeyProvider) UnwrapKey(ctx context.Context, wrapped string) ([]byte, error) {
+	key, err := p.WrapKey(ctx, []byte(wrapped))
+	return []byte(key), err
+}
+
+func (p reverseKeyProvider) NeedsRewrap(_ string) bool { return false }
+
+func testEnvelope(t *testing.T, activeID string) *Envelope {
+	keyring := testKeyring(t, activeID)
+	return NewEnvelope(&LocalKeyProvider{Keyring: keyring}, keyring)
+}
+
+func TestNotificationEnvelopeEncryption(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t, "2")
+
+	n := Notification{UUID: "uuid", Title: "title", Message: "message"}
+	if err := n.Encrypt(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if n.Title == "title" || len(n.DataKey) == 0 {
+		t.Errorf("notification should have been encrypted with a data key")
+	}
+	if needsReencrypt(&n, envelope) {
+		t.Errorf("notification encrypted with the current master key should not need re-encrypting")
+	}
+
+	// rotate the master key
+	rotated := testEnvelope(t, "1")
+	if !needsReencrypt(&n, rotated) {
+		t.Errorf("data key wrapped by a retired key should need rewrapping")
+	}
+	encryptedTitle := n.Title
+	if err := reencryptNotification(ctx, &n, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if n.Title != encryptedTitle {
+		t.Errorf("rewrapping should not have re-encrypted the content")
+	}
+
+	if err := n.Decrypt(ctx, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if n.Title != "title" || n.Message != "message" || len(n.DataKey) > 0 {
+		t.Errorf("got %+v, wanted decrypted notification", n)
+	}
+}
+
+func TestNotificationDecryptUnenveloped(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t, "2")
+
+	title, _ := envelope.Keyring.Encrypt("title")
+	n := Notification{Title: title}
+	if !needsReencrypt(&n, envelope) {
+		t.Errorf("notification without a data key should need re-encrypting")
+	}
+	if err := reencryptNotification(ctx, &n, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.DataKey) == 0 {
+		t.Errorf("notification should have been encrypted with a data key")
+	}
+
+	if err := n.Decrypt(ctx, envelope); err != nil || n.Title != "title" {
+		t.Errorf("got %s %v, wanted title", n.Title, err)
+	}
+}
+
+func TestEnvelopeChangeProvider(t *testing.T) {
+	ctx := context.Background()
+	local := testEnvelope(t, "2")
+	_, wrapped, err := local.NewDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote := NewEnvelope(reverseKeyProvider{}, local.Keyring)
+	if !remote.NeedsRewrap(wrapped) {
+		t.Errorf("data key of another provider should need rewrapping")
+	}
+	rewrapped, err := remote.Rewrap(ctx, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.NeedsRewrap(rewrapped) {
+		t.Errorf("rewrapped data key should not need rewrapping")
+	}
+
+	if _, err := local.DataKey(ctx, rewrapped); err == nil {
+		t.Errorf("unknown key provider should have errored")
+	}
+}
+
+func TestNotificationDecryptError(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t, "2")
+	n := Notification{Title: "title", Message: "message"}
+	if err := n.Encrypt(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	encryptedTitle := n.Title
+	n.Message = RandomString(40)
+
+	if err := n.Decrypt(ctx, envelope); err == nil {
+		t.Errorf("undecryptable message should have errored")
+	}
+	if n.Title != encryptedTitle {
+		t.Errorf("title should have been left encrypted")
+	}
+}
+
+func TestNotificationBoundContent(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t, "2")
+	n := Notification{UUID: "a", Credentials: "credentials", Title: "title", Message: "message"}
+	if err := n.Encrypt(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	swapped := n
+	swapped.Title, swapped.Message = n.Message, n.Title
+	if err := swapped.Decrypt(ctx, envelope); err == nil {
+		t.Errorf("content moved to another field should not have decrypted")
+	}
+
+	moved := n
+	moved.UUID = "b"
+	if err := moved.Decrypt(ctx, envelope); err == nil {
+		t.Errorf("content moved to another notification should not have decrypted")
+	}
+}
+
+func TestNotificationDecryptUnbound(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t, "2")
+	key, wrapped, err := envelope.NewDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	title, _ := EncryptAES("title", key)
+	n := Notification{UUID: "a", Title: title, DataKey: wrapped}
+	if !needsReencrypt(&n, envelope) {
+		t.Errorf("unbound content should need re-encrypting")
+	}
+	if err := reencryptNotification(ctx, &n, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if n.NeedsReencrypt() {
+		t.Errorf("re-encrypted content should have been bound")
+	}
+	if err := n.Decrypt(ctx, envelope); err != nil || n.Title != "title" {
+		t.Errorf("got %s %v, wanted title", n.Title, err)
+	}
+}
+
+func TestSealedNotificationReencrypt(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t, "2")
+
+	n := Notification{UUID: "a", Credentials: "credentials", Ciphertext: "sealed"}
+	if err := n.Encrypt(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.DataKey) > 0 || needsReencrypt(&n, testEnvelope(t, "1")) {
+		t.Errorf("end-to-end encrypted notification should be stored without a data key %+v", n)
+	}
+
+	// stored by an older version with a data key wrapped by a key that has since been removed
+	_, wrapped, err := testEnvelope(t, "1").NewDataKey(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.DataKey = wrapped
+	if !needsReencrypt(&n, envelope) {
+		t.Errorf("data key of end-to-end encrypted notification should be removed")
+	}
+	if err := reencryptNotification(ctx, &n, NewEnvelope(reverseKeyProvider{}, envelope.Keyring)); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.DataKey) > 0 || n.Ciphertext != "sealed" {
+		t.Errorf("got %+v, wanted ciphertext kept without a data key", n)
+	}
+
+	n.DataKey = wrapped
+	if err := n.Decrypt(ctx, NewEnvelope(reverseKeyProvider{}, envelope.Keyring)); err != nil || n.Ciphertext != "sealed" {
+		t.Errorf("got %v, end-to-end encrypted notification should not need its data key to be read", err)
+	}
+}
+
+// countingKMS is a KMS client whose keys are their own ciphertext, counting the decrypt calls
+type countingKMS struct {
+	kmsiface.KMSAPI
+	decrypts int
+}
+
+func (c *countingKMS) DecryptWithContext(_ aws.Context, in *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	c.decrypts++
+	return &kms.DecryptOutput{Plaintext: in.CiphertextBlob}, nil
+}
+
+func TestKMSKeyCache(t *testing.T) {
+	ctx := context.Background()
+	client := &countingKMS{}
+	p := &KMSKeyProvider{client: client, keyID: "alias/notifi", cache: map[string]cachedKey{}}
+	wrapped := b64.StdEncoding.EncodeToString([]byte("key"))
+
+	for i := 0; i < 3; i++ {
+		key, err := p.UnwrapKey(ctx, wrapped)
+		if err != nil || string(key) != "key" {
+			t.Fatalf("got %s %v, wanted the data key", key, err)
+		}
+		key[0] = 'x'
+	}
+	if client.decrypts != 1 {
+		t.Errorf("got %d decrypts, wanted the data key to be cached", client.decrypts)
+	}
+
+	c := p.cache[wrapped]
+	c.expires = time.Now().Add(-time.Second)
+	p.cache[wrapped] = c
+	if _, err := p.UnwrapKey(ctx, wrapped); err != nil || client.decrypts != 2 {
+		t.Errorf("got %d decrypts %v, wanted the expired data key to be unwrapped again", client.decrypts, err)
+	}
+
+	for i := 0; i < maxKMSKeyCacheLength+1; i++ {
+		p.store(b64.StdEncoding.EncodeToString([]byte{byte(i), byte(i >> 8)}), []byte("key"))
+	}
+	if len(p.cache) > maxKMSKeyCacheLength {
+		t.Errorf("got %d cached keys, wanted at most %d", len(p.cache), maxKMSKeyCacheLength)
+	}
+}
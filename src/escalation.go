@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/guregu/dynamo"
+	"github.com/notifi-backend/lambda-src/config"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// escalation policy limits
+const (
+	defaultEscalationInterval = 5 * time.Minute
+	minEscalationInterval     = 1 * time.Minute
+	maxEscalationInterval     = 24 * time.Hour
+	defaultEscalationAttempts = 5
+	maxEscalationAttempts     = 20
+)
+
+// EscalationPolicy describes how often and to whom an unacknowledged notification is re-sent
+type EscalationPolicy struct {
+	Interval             time.Duration
+	MaxAttempts          int
+	SecondaryCredentials string
+}
+
+// Escalation structure of a notification that is re-sent until it is acknowledged
+type Escalation struct {
+	UUID                 string       `dynamo:"uuid,hash"`
+	Credentials          string       `dynamo:"credentials"`
+	SecondaryCredentials string       `dynamo:"secondary_credentials,allowempty"`
+	IntervalSeconds      int          `dynamo:"interval_seconds"`
+	MaxAttempts          int          `dynamo:"max_attempts"`
+	Attempts             int          `dynamo:"attempts"`
+	NextAttempt          time.Time    `dynamo:"next_attempt_dttm"`
+	Notification         Notification `dynamo:"notification"`
+}
+
+// Validate runs validation on p EscalationPolicy
+func (p EscalationPolicy) Validate() error {
+	if p.Interval < minEscalationInterval {
+		return NewValidationError(EscalationReason, fmt.Sprintf("Escalation interval must be at least %d seconds!", int(minEscalationInterval.Seconds())))
+	}
+
+	if p.Interval > maxEscalationInterval {
+		return NewValidationError(EscalationReason, fmt.Sprintf("Escalation interval must be at most %d seconds!", int(maxEscalationInterval.Seconds())))
+	}
+
+	if p.MaxAttempts > maxEscalationAttempts {
+		return NewValidationError(EscalationReason, fmt.Sprintf("Escalation can not be attempted more than %d times!", maxEscalationAttempts))
+	}
+
+	if len(p.SecondaryCredentials) > 0 && !IsValidCredentials(p.SecondaryCredentials) {
+		return NewValidationError(EscalationReason, "Invalid credentials to escalate to!")
+	}
+	return nil
+}
+
+// NewEscalation creates an Escalation of an initialised notification n using policy p. The stored copy of the
+// notification is encrypted with envelope.
+func NewEscalation(ctx context.Context, db *DB, n Notification, p EscalationPolicy, envelope *Envelope) (Escalation, error) {
+	if err := n.Encrypt(ctx, envelope); err != nil {
+		return Escalation{}, err
+	}
+
+	e := Escalation{
+		UUID:            n.UUID,
+		Credentials:     n.Credentials,
+		IntervalSeconds: int(p.Interval.Seconds()),
+		MaxAttempts:     p.MaxAttempts,
+		NextAttempt:     time.Now().UTC().Add(p.Interval),
+		Notification:    n,
+	}
+	if len(p.SecondaryCredentials) > 0 {
+		// escalate to the hash the secondary user is stored under, which may predate LOOKUP_KEY
+		e.SecondaryCredentials = db.Hasher.Lookup(p.SecondaryCredentials)
+		if secondary, err := GetUserByCredentials(ctx, db, p.SecondaryCredentials); err == nil {
+			e.SecondaryCredentials = secondary.Credentials
+		}
+	}
+	return e, nil
+}
+
+// Store stores e Escalation in the database
+func (e *Escalation) Store(ctx context.Context, db *DB) error {
+	return db.Escalations().Put(e).RunWithContext(ctx)
+}
+
+// Escalate re-sends the notification of e Escalation to the primary and secondary users and schedules the next
+// attempt. The escalation is removed once it has been attempted MaxAttempts times.
+func (e *Escalation) Escalate(ctx context.Context, cfg *config.Config, db *DB, envelope *Envelope) error {
+	notification := e.Notification
+	if err := notification.Decrypt(ctx, envelope); err != nil {
+		// the attempt is counted so an undecryptable escalation is removed instead of failing every run
+		decryptFailuresTotal.Inc("escalate")
+		if attemptErr := e.attempted(ctx, db); attemptErr != nil {
+			return attemptErr
+		}
+		return err
+	}
+
+	credentials := []string{e.Credentials}
+	if len(e.SecondaryCredentials) > 0 {
+		credentials = append(credentials, e.SecondaryCredentials)
+	}
+	for _, c := range credentials {
+		var user User
+		if err := db.Users().Get("credentials", c).Index("credentials-index").OneWithContext(ctx, &user); err != nil {
+			Logger(ctx).WithFields(logrus.Fields{
+				"uuid": e.UUID,
+				"err":  err.Error(),
+			}).Warn("unable to find user to escalate to")
+			continue
+		}
+		usage, err := notification.Send(ctx, cfg, user)
+		if err != nil {
+			if err := queueEscalated(ctx, db, envelope, notification, user); err != nil {
+				Logger(ctx).WithFields(logrus.Fields{
+					"uuid": e.UUID,
+					"err":  err.Error(),
+				}).Error("unable to queue escalated notification")
+			} else {
+				usage.Queued++
+			}
+		}
+		usage.Record(ctx, db, user.Credentials)
+	}
+
+	return e.attempted(ctx, db)
+}
+
+// queueEscalated stores the escalated notification n for the offline user to receive from the backlog. Notifications
+// are stored under their uuid, so while the notification is still queued for the other user of the escalation this
+// user receives the next attempt instead.
+func queueEscalated(ctx context.Context, db *DB, envelope *Envelope, n Notification, user User) error {
+	n.Credentials = user.Credentials
+	if err := n.Encrypt(ctx, envelope); err != nil {
+		return err
+	}
+	err := db.Notifications().
+		Put(n).
+		If("attribute_not_exists('uuid') OR 'credentials' = ?", user.Credentials).
+		RunWithContext(ctx)
+	if dynamo.IsCondCheckFailed(err) {
+		return nil
+	}
+	return err
+}
+
+// attempted counts an attempt of e Escalation and schedules the next one, removing the escalation once it has been
+// attempted MaxAttempts times
+func (e *Escalation) attempted(ctx context.Context, db *DB) error {
+	e.Attempts++
+	if e.Attempts >= e.MaxAttempts {
+		return db.Escalations().Delete("uuid", e.UUID).RunWithContext(ctx)
+	}
+
+	e.NextAttempt = time.Now().UTC().Add(time.Duration(e.IntervalSeconds) * time.Second)
+	err := db.Escalations().
+		Update("uuid", e.UUID).
+		Set("attempts", e.Attempts).
+		Set("next_attempt_dttm", e.NextAttempt).
+		If("attribute_exists('uuid')").
+		RunWithContext(ctx)
+	if dynamo.IsCondCheckFailed(err) {
+		// acknowledged while escalating
+		return nil
+	}
+	return err
+}
+
+// GetEscalations returns the escalations of notifications sent to the hashed credentials
+func GetEscalations(ctx context.Context, db *DB, credentials string) ([]Escalation, error) {
+	var escalations []Escalation
+	err := db.Escalations().Get("credentials", credentials).Index("credentials-index").AllWithContext(ctx, &escalations)
+	if errors.Is(err, dynamo.ErrNotFound) {
+		return nil, nil
+	}
+	return escalations, err
+}
+
+// AcknowledgeEscalations stops the escalation of the notification uuids that were sent to credentials
+func AcknowledgeEscalations(ctx context.Context, db *DB, credentials string, uuids []string) error {
+	for _, UUID := range uuids {
+		err := db.Escalations().
+			Delete("uuid", UUID).
+			If("'credentials' = ? OR 'secondary_credentials' = ?", credentials, credentials).
+			RunWithContext(ctx)
+		if err != nil && !dynamo.IsCondCheckFailed(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleEscalate is run on a schedule and re-sends all notifications that are due to be escalated
+func (h *Handlers) HandleEscalate(ctx context.Context, _ events.CloudWatchEvent) error {
+	// escalations have no key in common to query the due ones by, so the table is scanned. It only holds
+	// unacknowledged escalations, which are removed after at most maxEscalationAttempts.
+	var escalations []Escalation
+	err := h.DB.Escalations().Scan().Filter("'next_attempt_dttm' <= ?", time.Now().UTC()).AllWithContext(ctx, &escalations)
+	if err != nil {
+		return err
+	}
+
+	for i := range escalations {
+		if err := escalations[i].Escalate(ctx, h.Config, h.DB, h.Envelope); err != nil {
+			Logger(ctx).WithFields(logrus.Fields{
+				"uuid": escalations[i].UUID,
+				"err":  err.Error(),
+			}).Error("problem escalating notification")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEscalationPolicyDefaults(t *testing.T) {
+	n := Notification{Escalate: true}
+	policy := n.EscalationPolicy()
+	if policy.Interval != defaultEscalationInterval {
+		t.Errorf("got interval %v, wanted %v", policy.Interval, defaultEscalationInterval)
+	}
+	if policy.MaxAttempts != defaultEscalationAttempts {
+		t.Errorf("got %d attempts, wanted %d", policy.MaxAttempts, defaultEscalationAttempts)
+	}
+}
+
+var escalationPolicyTests = []struct {
+	name   string
+	policy EscalationPolicy
+	valid  bool
+}{
+	{"default", EscalationPolicy{Interval: defaultEscalationInterval, MaxAttempts: defaultEscalationAttempts}, true},
+	{"short interval", EscalationPolicy{Interval: time.Second, MaxAttempts: 1}, false},
+	{"long interval", EscalationPolicy{Interval: maxEscalationInterval + time.Second, MaxAttempts: 1}, false},
+	{"too many attempts", EscalationPolicy{Interval: time.Hour, MaxAttempts: maxEscalationAttempts + 1}, false},
+	{"secondary", EscalationPolicy{Interval: time.Hour, MaxAttempts: 1, SecondaryCredentials: RandomString(credentialLen)}, true},
+	{"invalid secondary", EscalationPolicy{Interval: time.Hour, MaxAttempts: 1, SecondaryCredentials: "foo"}, false},
+}
+
+func TestEscalationPolicyValidity(t *testing.T) {
+	for _, tt := range escalationPolicyTests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err == nil) != tt.valid {
+				t.Errorf("got %v, wanted valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestEscalateIntervalOverflow(t *testing.T) {
+	n := Notification{Credentials: RandomString(credentialLen), Title: "title", Escalate: true, EscalateInterval: 60}
+	if err := n.Validate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// wraps around to an interval of 60 seconds
+	n.EscalateInterval = 18446744134
+	if err := n.Validate(context.Background()); err == nil {
+		t.Errorf("an interval overflowing a time.Duration should be invalid")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"time"
+)
+
+// export formats
+const (
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+// maxLambdaExportBytes is the ExportLimit of lambda responses, which are capped at 6 MB after the body is escaped
+// into the JSON of the response
+const maxLambdaExportBytes = 4 << 20
+
+// exportCloseBytes is the room left under the limit of an export to close it
+const exportCloseBytes = 32
+
+// errExportLimit is returned when a notification would take an export over its limit
+var errExportLimit = errors.New("export limit reached")
+
+// DeviceExport structure of the stored metadata of a device
+type DeviceExport struct {
+	AppVersion      string    `json:"app_version"`
+	OS              string    `json:"operating_system"`
+	Created         time.Time `json:"created"`
+	LastLogin       time.Time `json:"last_login"`
+	NotificationCnt int       `json:"notification_cnt"`
+}
+
+// NewDeviceExport returns the DeviceExport of user
+func NewDeviceExport(user User) DeviceExport {
+	return DeviceExport{
+		AppVersion:      user.AppVersion,
+		OS:              user.OS,
+		Created:         user.Created,
+		LastLogin:       user.LastLogin,
+		NotificationCnt: user.NotificationCnt,
+	}
+}
+
+// ExportWriter writes an export of a device and its notifications one at a time. As JSON the export is a single
+// {"device": {...}, "notifications": [...]} object and as NDJSON the device is the first line followed by a line per
+// notification. An export that reaches its limit or fails is closed with "truncated": true.
+type ExportWriter struct {
+	w      io.Writer
+	format string
+	count  int
+
+	// Limit is the most bytes written, 0 for no limit
+	Limit     int
+	written   int
+	truncated bool
+	failed    bool
+}
+
+// NewExportWriter creates an ExportWriter writing to w in format, which must be ExportJSON or ExportNDJSON
+func NewExportWriter(w io.Writer, format string) (*ExportWriter, error) {
+	if format != ExportJSON && format != ExportNDJSON {
+		return nil, fmt.Errorf("Unknown export format '%s'!", format)
+	}
+	return &ExportWriter{w: w, format: format}, nil
+}
+
+// write writes b to the export, counting the bytes written
+func (e *ExportWriter) write(b []byte) error {
+	n, err := e.w.Write(b)
+	e.written += n
+	return err
+}
+
+// ContentType returns the content type of the export
+func (e *ExportWriter) ContentType() string {
+	if e.format == ExportNDJSON {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+// Device writes the device, which must be written first
+func (e *ExportWriter) Device(device DeviceExport) error {
+	b, err := json.Marshal(device)
+	if err != nil {
+		return err
+	}
+
+	if e.format == ExportNDJSON {
+		return e.write(append(b, '\n'))
+	}
+	return e.write([]byte(fmt.Sprintf(`{"device":%s,"notifications":[`, b)))
+}
+
+// Notification writes a notification, returning errExportLimit instead if it would take the export over its limit
+func (e *ExportWriter) Notification(n Notification) error {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	if e.Limit > 0 && e.written+len(b)+1+exportCloseBytes > e.Limit {
+		e.truncated = true
+		return errExportLimit
+	}
+
+	switch {
+	case e.format == ExportNDJSON:
+		err = e.write(append(b, '\n'))
+	case e.count > 0:
+		err = e.write(append([]byte(","), b...))
+	default:
+		err = e.write(b)
+	}
+	e.count++
+	return err
+}
+
+// Close finishes the export, marking it truncated if it reached its limit or failed
+func (e *ExportWriter) Close() error {
+	marker := `"truncated":true`
+	if e.failed {
+		marker += `,"error":"Export failed"`
+	}
+
+	switch {
+	case e.format == ExportNDJSON && e.truncated:
+		return e.write([]byte("{" + marker + "}\n"))
+	case e.format == ExportNDJSON:
+		return nil
+	case e.truncated:
+		return e.write([]byte("]," + marker + "}"))
+	default:
+		return e.write([]byte("]}"))
+	}
+}
+
+// Abort closes an export that failed after it was started, marking it truncated with an error so it is not taken
+// for a complete export
+func (e *ExportWriter) Abort() error {
+	e.truncated, e.failed = true, true
+	return e.Close()
+}
+
+// Started returns whether anything has been written to the export
+func (e *ExportWriter) Started() bool {
+	return e.written > 0
+}
+
+// Export writes the export of user and the decrypted queued notifications of every credentials it has been issued to
+// e, a notification at a time so large backlogs are not held in memory. Notifications that can not be decrypted are
+// left out.
+func Export(ctx context.Context, db *DB, envelope *Envelope, user User, e *ExportWriter, flush func()) error {
+	if err := e.Device(NewDeviceExport(user)); err != nil {
+		return err
+	}
+
+	for _, credentials := range user.issuedCredentials() {
+		var n Notification
+		iter := db.Notifications().Get("credentials", credentials).Index("credentials-index").Iter()
+		for iter.NextWithContext(ctx, &n) {
+			if err := n.Decrypt(ctx, envelope); err != nil {
+				// one unreadable row must not stop the rest of the device being exported
+				decryptFailuresTotal.Inc("export")
+				Logger(ctx).WithFields(logrus.Fields{
+					"uuid": n.UUID,
+					"err":  err.Error(),
+				}).Error("problem decrypting exported notification")
+				n = Notification{}
+				continue
+			}
+			if err := e.Notification(n); errors.Is(err, errExportLimit) {
+				return e.Close()
+			} else if err != nil {
+				return err
+			}
+			flush()
+			n = Notification{}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return e.Close()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// HandleExport streams the export of the device authenticated by its UUID, credentials and credential_key as JSON,
+// or as NDJSON if the format form value is ndjson or application/x-ndjson is accepted. The export is truncated at
+// the ExportLimit of h.
+func (h *Handlers) HandleExport(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.AuthenticateDevice(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.Form.Get("format")
+	if len(format) == 0 {
+		format = ExportJSON
+		if strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+			format = ExportNDJSON
+		}
+	}
+	e, err := NewExportWriter(w, format)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	e.Limit = h.ExportLimit
+
+	flush := func() {}
+	if f, ok := w.(http.Flusher); ok {
+		flush = f.Flush
+	}
+
+	w.Header().Set("Content-Type", e.ContentType())
+	if err := Export(r.Context(), h.DB, h.Envelope, user, e, flush); err != nil {
+		Logger(r.Context()).WithField("err", err.Error()).Error("problem exporting notifications")
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		if !e.Started() {
+			WriteHttpError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		// the response has already started so the export is ended as incomplete
+		_ = e.Abort()
+	}
+}
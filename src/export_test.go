@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func writeExport(t *testing.T, format string, notifications int) string {
+	var b bytes.Buffer
+	e, err := NewExportWriter(&b, format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Device(DeviceExport{AppVersion: "1.0", NotificationCnt: notifications}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < notifications; i++ {
+		if err := e.Notification(Notification{Title: "title"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestExportJSON(t *testing.T) {
+	for _, cnt := range []int{0, 1, 3} {
+		var export struct {
+			Device        DeviceExport   `json:"device"`
+			Notifications []Notification `json:"notifications"`
+		}
+		out := writeExport(t, ExportJSON, cnt)
+		if err := json.Unmarshal([]byte(out), &export); err != nil {
+			t.Fatalf("%s: %v", out, err)
+		}
+		if export.Device.AppVersion != "1.0" || len(export.Notifications) != cnt {
+			t.Errorf("got %+v, wanted %d notifications", export, cnt)
+		}
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	lines := strings.Split(strings.TrimSuffix(writeExport(t, ExportNDJSON, 2), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, wanted device and 2 notifications", len(lines))
+	}
+
+	var device DeviceExport
+	if err := json.Unmarshal([]byte(lines[0]), &device); err != nil || device.AppVersion != "1.0" {
+		t.Errorf("got %+v %v, wanted device first", device, err)
+	}
+	for _, line := range lines[1:] {
+		var n Notification
+		if err := json.Unmarshal([]byte(line), &n); err != nil || n.Title != "title" {
+			t.Errorf("got %+v %v, wanted notification", n, err)
+		}
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if _, err := NewExportWriter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Errorf("unknown format should have errored")
+	}
+}
+
+func TestExportLimit(t *testing.T) {
+	for _, format := range []string{ExportJSON, ExportNDJSON} {
+		var b bytes.Buffer
+		e, _ := NewExportWriter(&b, format)
+		e.Limit = 512
+		if err := e.Device(DeviceExport{AppVersion: "1.0"}); err != nil {
+			t.Fatal(err)
+		}
+		written := 0
+		for ; written < 100; written++ {
+			if err := e.Notification(Notification{Title: "title"}); errors.Is(err, errExportLimit) {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if written == 0 || written == 100 || b.Len() > e.Limit {
+			t.Errorf("%s: got %d notifications in %d bytes, wanted export capped at %d", format, written, b.Len(), e.Limit)
+		}
+		if !strings.Contains(b.String(), `"truncated":true`) {
+			t.Errorf("%s: truncated export should have been marked %s", format, b.String())
+		}
+		if format == ExportJSON && !json.Valid(b.Bytes()) {
+			t.Errorf("truncated export should be valid json %s", b.String())
+		}
+	}
+}
+
+func TestExportAbort(t *testing.T) {
+	for _, format := range []string{ExportJSON, ExportNDJSON} {
+		var b bytes.Buffer
+		e, _ := NewExportWriter(&b, format)
+		if e.Started() {
+			t.Errorf("%s: nothing has been written", format)
+		}
+		if err := e.Device(DeviceExport{AppVersion: "1.0"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Notification(Notification{Title: "title"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Abort(); err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(b.String(), `"truncated":true,"error":"Export failed"`) {
+			t.Errorf("%s: failed export should have been marked %s", format, b.String())
+		}
+		if format == ExportJSON && !json.Valid(b.Bytes()) {
+			t.Errorf("failed export should be valid json %s", b.String())
+		}
+	}
+}
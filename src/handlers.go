@@ -0,0 +1,81 @@
+package main
+
+import (
+	"github.com/notifi-backend/lambda-src/config"
+)
+
+// Handlers handles every request with the configuration and clients it is created with
+type Handlers struct {
+	Config         *config.Config
+	DB             *DB
+	RateLimits     RateLimitStore
+	RateLimitPlans map[string]RateLimit
+	Failures       FailureStore
+	Dedupes        DedupeStore
+	Envelope       *Envelope
+
+	// ServerKeys are the server keys clients can send by id
+	ServerKeys map[string]string
+
+	// ExportLimit caps the bytes of an export when responses can not be streamed, 0 for no cap
+	ExportLimit int
+}
+
+// NewHandlers creates the Handlers of the validated cfg
+func NewHandlers(cfg *config.Config) (*Handlers, error) {
+	db := NewDB(cfg)
+
+	rateLimits, err := NewRateLimitStore(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	plans, err := ParseRateLimitPlans(cfg.RateLimitPlans)
+	if err != nil {
+		return nil, err
+	}
+
+	failures, err := NewFailureStore(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	dedupes, err := NewDedupeStore(cfg, db)
+	if err != nil {
+		return nil, err
+	}
+
+	serverKeys, err := cfg.ServerKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope *Envelope
+	if len(cfg.EncryptionKey) > 0 {
+		keys, err := cfg.EncryptionKeys()
+		if err != nil {
+			return nil, err
+		}
+		keyring, err := NewKeyring(cfg.EncryptionKeyID, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		var provider KeyProvider = &LocalKeyProvider{Keyring: keyring}
+		if cfg.KeyProvider == "kms" {
+			provider = NewKMSKeyProvider(cfg)
+		}
+		envelope = NewEnvelope(provider, keyring)
+	}
+
+	return &Handlers{
+		Config:         cfg,
+		DB:             db,
+		RateLimits:     rateLimits,
+		RateLimitPlans: plans,
+		Failures:       failures,
+		Dedupes:        dedupes,
+		Envelope:       envelope,
+		ServerKeys:     serverKeys,
+	}, nil
+}
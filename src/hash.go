@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	b64 "encoding/base64"
+	"fmt"
+	"github.com/notifi-backend/lambda-src/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"strings"
+)
+
+// lookupHashPrefix versions the hashes keyed with LOOKUP_KEY so they can be told apart from the unkeyed hashes of
+// rows stored before it was set
+const lookupHashPrefix = "k1:"
+
+// maxBcryptPasswordLen is the number of bytes of a password bcrypt uses
+const maxBcryptPasswordLen = 72
+
+// argon2id parameters credential keys are hashed with
+const (
+	argon2Memory  = 19 * 1024
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// Hasher hashes the values rows are looked up by, such as device uuids and credentials, and the credential keys users
+// are verified with
+type Hasher struct {
+	lookupKey  []byte
+	algorithm  string
+	bcryptCost int
+}
+
+// NewHasher creates a Hasher of the LOOKUP_KEY, PASSWORD_HASH and BCRYPT_COST of cfg
+func NewHasher(cfg *config.Config) *Hasher {
+	h := &Hasher{
+		lookupKey:  []byte(cfg.LookupKey),
+		algorithm:  cfg.PasswordHash,
+		bcryptCost: cfg.BcryptCost,
+	}
+	if len(h.algorithm) == 0 {
+		h.algorithm = config.PasswordHashBcrypt
+	}
+	if h.bcryptCost == 0 {
+		h.bcryptCost = config.DefaultBcryptCost
+	}
+	return h
+}
+
+// Lookup hashes str to be stored and looked up by. Without a LOOKUP_KEY it is the unkeyed Hash.
+func (h *Hasher) Lookup(str string) string {
+	if len(h.lookupKey) == 0 {
+		return Hash(str)
+	}
+	mac := hmac.New(sha256.New, h.lookupKey)
+	mac.Write([]byte(str))
+	return lookupHashPrefix + b64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// LookupCandidates returns the hashes a row of str could be stored under, the Lookup hash first followed by the
+// unkeyed Hash of rows stored before LOOKUP_KEY was set
+func (h *Hasher) LookupCandidates(str string) []string {
+	if len(h.lookupKey) == 0 {
+		return []string{Hash(str)}
+	}
+	return []string{h.Lookup(str), Hash(str)}
+}
+
+// MatchesLookup returns whether hash is one of the LookupCandidates of str
+func (h *Hasher) MatchesLookup(hash, str string) bool {
+	for _, candidate := range h.LookupCandidates(str) {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(candidate)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// Password hashes the credential key str with the configured PASSWORD_HASH. bcrypt hashes are in the modular crypt
+// format ($2a$<cost>$...) and argon2id hashes in the PHC string format ($argon2id$v=19$m=,t=,p=$<salt>$<hash>).
+func (h *Hasher) Password(str string) (string, error) {
+	if h.algorithm == config.PasswordHashArgon2id {
+		salt := make([]byte, argon2SaltLen)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(str), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			b64.RawStdEncoding.EncodeToString(salt), b64.RawStdEncoding.EncodeToString(key)), nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword(bcryptPassword(str), h.bcryptCost)
+	return string(hash), err
+}
+
+// VerifyPassword verifies str is the credential key of hash, whichever format it was hashed with
+func (h *Hasher) VerifyPassword(hash, str string) bool {
+	if params, ok := parseArgon2Hash(hash); ok {
+		key := argon2.IDKey([]byte(str), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+		return subtle.ConstantTimeCompare(key, params.key) == 1
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), bcryptPassword(str)) == nil
+}
+
+// bcryptPassword truncates str to the bytes bcrypt uses, as it did itself before rejecting longer passwords, so
+// credential keys hashed by older versions still verify
+func bcryptPassword(str string) []byte {
+	if len(str) > maxBcryptPasswordLen {
+		str = str[:maxBcryptPasswordLen]
+	}
+	return []byte(str)
+}
+
+// NeedsRehash returns whether hash was not hashed with the configured PASSWORD_HASH and parameters
+func (h *Hasher) NeedsRehash(hash string) bool {
+	if params, ok := parseArgon2Hash(hash); ok {
+		return h.algorithm != config.PasswordHashArgon2id || params.memory != argon2Memory ||
+			params.time != argon2Time || params.threads != argon2Threads
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || h.algorithm != config.PasswordHashBcrypt || cost != h.bcryptCost
+}
+
+// argon2Hash structure of the parameters of an argon2id PHC string
+type argon2Hash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2Hash(hash string) (argon2Hash, bool) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return argon2Hash{}, false
+	}
+
+	var version int
+	var params argon2Hash
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Hash{}, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return argon2Hash{}, false
+	}
+
+	var err error
+	if params.salt, err = b64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Hash{}, false
+	}
+	if params.key, err = b64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return argon2Hash{}, false
+	}
+	return params, true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/notifi-backend/lambda-src/config"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+var testLookupKey = strings.Repeat("k", config.MinLookupKeyLen)
+
+func TestPassword(t *testing.T) {
+	for _, algorithm := range []string{config.PasswordHashBcrypt, config.PasswordHashArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			hasher := NewHasher(&config.Config{PasswordHash: algorithm, BcryptCost: bcrypt.MinCost})
+			password := RandomString(credentialKeyLen)
+
+			hash, err := hasher.Password(password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hash2, _ := hasher.Password(password)
+			if hash == hash2 {
+				t.Errorf("hashed passwords should be different")
+			}
+
+			if !hasher.VerifyPassword(hash, password) {
+				t.Errorf("password should have verified successfully")
+			}
+			if hasher.VerifyPassword(hash, RandomString(credentialKeyLen)) {
+				t.Errorf("wrong password should not have verified")
+			}
+			if hasher.NeedsRehash(hash) {
+				t.Errorf("password hashed with the configured algorithm should not need rehashing")
+			}
+		})
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	password := RandomString(credentialKeyLen)
+	// credential keys were truncated to the bytes bcrypt uses
+	legacy, _ := bcrypt.GenerateFromPassword([]byte(password[:maxBcryptPasswordLen]), bcrypt.MinCost)
+	argon2id, _ := NewHasher(&config.Config{PasswordHash: config.PasswordHashArgon2id}).Password(password)
+
+	hasher := NewHasher(&config.Config{BcryptCost: bcrypt.MinCost + 1})
+	if !hasher.NeedsRehash(string(legacy)) {
+		t.Errorf("bcrypt hash with a lower cost should need rehashing")
+	}
+	if !hasher.NeedsRehash(argon2id) {
+		t.Errorf("argon2id hash should need rehashing to bcrypt")
+	}
+	if !hasher.VerifyPassword(argon2id, password) || !hasher.VerifyPassword(string(legacy), password) {
+		t.Errorf("hashes of other formats should still verify")
+	}
+	if hasher.VerifyPassword("$argon2id$v=19$m=1,t=1,p=1$!$!", password) {
+		t.Errorf("malformed hash should not verify")
+	}
+}
+
+func TestLookup(t *testing.T) {
+	unkeyed := NewHasher(&config.Config{})
+	if unkeyed.Lookup("uuid") != Hash("uuid") || len(unkeyed.LookupCandidates("uuid")) != 1 {
+		t.Errorf("lookup without a key should be the unkeyed hash")
+	}
+
+	keyed := NewHasher(&config.Config{LookupKey: testLookupKey})
+	hash := keyed.Lookup("uuid")
+	if !strings.HasPrefix(hash, lookupHashPrefix) || hash == Hash("uuid") {
+		t.Errorf("expected keyed hash got %s", hash)
+	}
+	if hash == NewHasher(&config.Config{LookupKey: strings.Repeat("o", config.MinLookupKeyLen)}).Lookup("uuid") {
+		t.Errorf("hashes of different keys should differ")
+	}
+
+	candidates := keyed.LookupCandidates("uuid")
+	if len(candidates) != 2 || candidates[0] != hash || candidates[1] != Hash("uuid") {
+		t.Errorf("expected keyed then unkeyed candidates got %v", candidates)
+	}
+	if !keyed.MatchesLookup(Hash("uuid"), "uuid") || !keyed.MatchesLookup(hash, "uuid") || keyed.MatchesLookup(hash, "other") {
+		t.Errorf("unexpected lookup match")
+	}
+}
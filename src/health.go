@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/notifi-backend/lambda-src/config"
+	"net/http"
+)
+
+// statuses of a health check
+const (
+	HealthOK      = "ok"
+	HealthFail    = "fail"
+	HealthSkipped = "skipped"
+)
+
+// errHealthSkipped is returned by a health check of an optional dependency that is not configured
+var errHealthSkipped = errors.New("not configured")
+
+// HealthCheck is a named check of a dependency
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// HealthCheckResult structure of the result of a single HealthCheck
+type HealthCheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthReport structure of the results of all HealthChecks
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+// LivenessChecks returns the health checks of the configuration of the running process
+func (h *Handlers) LivenessChecks() []HealthCheck {
+	return []HealthCheck{
+		{"encryption_key", h.CheckEncryptionKey},
+		{"firebase_credentials", h.CheckFirebaseCredentials},
+		{"websocket_endpoint", h.CheckWebsocketEndpoint},
+	}
+}
+
+// ReadinessChecks returns the health checks of everything needed to serve requests
+func (h *Handlers) ReadinessChecks() []HealthCheck {
+	return append([]HealthCheck{{"storage", h.CheckStorage}}, h.LivenessChecks()...)
+}
+
+// RunHealthChecks runs checks and reports the result of each
+func RunHealthChecks(ctx context.Context, checks []HealthCheck) HealthReport {
+	report := HealthReport{Status: HealthOK, Checks: map[string]HealthCheckResult{}}
+	for _, check := range checks {
+		result := HealthCheckResult{Status: HealthOK}
+		if err := check.Check(ctx); errors.Is(err, errHealthSkipped) {
+			result.Status = HealthSkipped
+		} else if err != nil {
+			result.Status = HealthFail
+			result.Error = err.Error()
+			report.Status = HealthFail
+		}
+		report.Checks[check.Name] = result
+	}
+	return report
+}
+
+// CheckEncryptionKey checks ENCRYPTION_KEY is a valid AES-256 key
+func (h *Handlers) CheckEncryptionKey(_ context.Context) error {
+	return config.ValidateEncryptionKey(h.Config.EncryptionKey)
+}
+
+// CheckFirebaseCredentials checks FIREBASE_CREDENTIALS_JSON_B64 decodes to json credentials
+func (h *Handlers) CheckFirebaseCredentials(_ context.Context) error {
+	if len(h.Config.FirebaseCredentialsJSONB64) == 0 {
+		return errHealthSkipped
+	}
+	return config.ValidateFirebaseCredentials(h.Config.FirebaseCredentialsJSONB64)
+}
+
+// CheckWebsocketEndpoint checks WS_ENDPOINT is a url of the websocket management api
+func (h *Handlers) CheckWebsocketEndpoint(_ context.Context) error {
+	if len(h.Config.WSEndpoint) == 0 {
+		return errors.New("WS_ENDPOINT is not set")
+	}
+	return config.ValidateWSEndpoint(h.Config.WSEndpoint)
+}
+
+// CheckStorage checks every configured table can be reached
+func (h *Handlers) CheckStorage(ctx context.Context) error {
+	for _, table := range h.DB.configuredTables() {
+		if _, err := h.DB.Table(table).Describe().RunWithContext(ctx); err != nil {
+			return fmt.Errorf("table '%s': %w", table, err)
+		}
+	}
+	return nil
+}
+
+func writeHealthReport(w http.ResponseWriter, report HealthReport) {
+	b, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != HealthOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_, _ = w.Write(b)
+}
+
+func (h *Handlers) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, RunHealthChecks(r.Context(), h.LivenessChecks()))
+}
+
+func (h *Handlers) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	writeHealthReport(w, RunHealthChecks(r.Context(), h.ReadinessChecks()))
+}
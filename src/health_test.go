@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/notifi-backend/lambda-src/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var encryptionKeyTests = []struct {
+	in    string
+	valid bool
+}{
+	{"", false},
+	{RandomString(16), false},
+	{RandomString(config.EncryptionKeyLen), true},
+}
+
+func TestCheckEncryptionKey(t *testing.T) {
+	for _, tt := range encryptionKeyTests {
+		t.Run(tt.in, func(t *testing.T) {
+			h := Handlers{Config: &config.Config{EncryptionKey: tt.in}}
+			err := h.CheckEncryptionKey(context.Background())
+			if (err == nil) != tt.valid {
+				t.Errorf("got %v, wanted valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+var firebaseCredentialsTests = []struct {
+	name string
+	in   string
+	err  bool
+}{
+	{"not base64", "!", true},
+	{"not json", base64.StdEncoding.EncodeToString([]byte("foo")), true},
+	{"valid", base64.StdEncoding.EncodeToString([]byte(`{"type": "service_account"}`)), false},
+}
+
+func TestCheckFirebaseCredentials(t *testing.T) {
+	h := Handlers{Config: &config.Config{}}
+	if err := h.CheckFirebaseCredentials(context.Background()); !errors.Is(err, errHealthSkipped) {
+		t.Errorf("missing credentials should have been skipped")
+	}
+
+	for _, tt := range firebaseCredentialsTests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Handlers{Config: &config.Config{FirebaseCredentialsJSONB64: tt.in}}
+			err := h.CheckFirebaseCredentials(context.Background())
+			if (err != nil) != tt.err {
+				t.Errorf("got %v, wanted error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	h := Handlers{Config: &config.Config{
+		EncryptionKey: RandomString(config.EncryptionKeyLen),
+		WSEndpoint:    "https://example.com/prod",
+	}}
+
+	w := httptest.NewRecorder()
+	h.HandleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d, wanted %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	h.Config.WSEndpoint = ""
+	w = httptest.NewRecorder()
+	h.HandleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("got %d, wanted %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
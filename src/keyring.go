@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ciphertextVersion prefixes ciphertext encrypted by a Keyring as <version>:<key id>:<base64 ciphertext>
+const ciphertextVersion = "v1"
+
+// Keyring holds the active key notifications are encrypted with and the retired keys that can still decrypt them
+type Keyring struct {
+	ActiveID string
+	keys     map[string][]byte
+}
+
+// NewKeyring creates a Keyring of keys by id that encrypts with the key activeID
+func NewKeyring(activeID string, keys map[string]string) (*Keyring, error) {
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("no encryption key with id '%s'", activeID)
+	}
+
+	k := &Keyring{ActiveID: activeID, keys: map[string][]byte{}}
+	for id, key := range keys {
+		if strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid encryption key id '%s'", id)
+		}
+		k.keys[id] = []byte(key)
+	}
+	return k, nil
+}
+
+// Encrypt encrypts str with the active key
+func (k *Keyring) Encrypt(str string) (string, error) {
+	if len(str) == 0 {
+		return "", nil
+	}
+
+	ciphertext, err := EncryptAES(str, k.keys[k.ActiveID])
+	if err != nil {
+		return "", err
+	}
+	return ciphertextVersion + ":" + k.ActiveID + ":" + ciphertext, nil
+}
+
+// Decrypt decrypts str with the key it was encrypted with. Unversioned ciphertext from before keys had ids is
+// decrypted with whichever key it was encrypted with.
+func (k *Keyring) Decrypt(str string) (string, error) {
+	if len(str) == 0 {
+		return "", nil
+	}
+
+	id, ciphertext, versioned := parseCiphertext(str)
+	if !versioned {
+		return k.decryptUnversioned(str)
+	}
+
+	key, ok := k.keys[id]
+	if !ok {
+		return "", fmt.Errorf("no encryption key with id '%s'", id)
+	}
+	return DecryptAES(ciphertext, key)
+}
+
+func (k *Keyring) decryptUnversioned(str string) (string, error) {
+	ids := []string{k.ActiveID}
+	for id := range k.keys {
+		if id != k.ActiveID {
+			ids = append(ids, id)
+		}
+	}
+
+	for _, id := range ids {
+		if plaintext, err := DecryptAES(str, k.keys[id]); err == nil && len(plaintext) > 0 {
+			return plaintext, nil
+		}
+	}
+	return "", errors.New("unable to decrypt with any encryption key")
+}
+
+// NeedsReencrypt returns whether str was not encrypted with the active key
+func (k *Keyring) NeedsReencrypt(str string) bool {
+	if len(str) == 0 {
+		return false
+	}
+	id, _, versioned := parseCiphertext(str)
+	return !versioned || id != k.ActiveID
+}
+
+// parseCiphertext splits versioned ciphertext into the key id and base64 ciphertext
+func parseCiphertext(str string) (id, ciphertext string, versioned bool) {
+	version, rest, ok := strings.Cut(str, ":")
+	if !ok || version != ciphertextVersion {
+		return "", "", false
+	}
+	id, ciphertext, ok = strings.Cut(rest, ":")
+	return id, ciphertext, ok
+}
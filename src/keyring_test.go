@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var retiredKey = RandomString(32)
+
+func testKeyring(t *testing.T, activeID string) *Keyring {
+	keyring, err := NewKeyring(activeID, map[string]string{"1": retiredKey, "2": string(testKey)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestKeyringEncrypt(t *testing.T) {
+	keyring := testKeyring(t, "2")
+	ciphertext, err := keyring.Encrypt(testStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ciphertext, "v1:2:") {
+		t.Errorf("ciphertext %s should be versioned with the active key id", ciphertext)
+	}
+	if keyring.NeedsReencrypt(ciphertext) {
+		t.Errorf("ciphertext of the active key should not need re-encrypting")
+	}
+
+	plaintext, err := keyring.Decrypt(ciphertext)
+	if err != nil || plaintext != testStr {
+		t.Errorf("got %s %v, wanted %s", plaintext, err, testStr)
+	}
+}
+
+func TestKeyringDecryptRetired(t *testing.T) {
+	ciphertext, _ := testKeyring(t, "1").Encrypt(testStr)
+
+	keyring := testKeyring(t, "2")
+	if !keyring.NeedsReencrypt(ciphertext) {
+		t.Errorf("ciphertext of a retired key should need re-encrypting")
+	}
+	plaintext, err := keyring.Decrypt(ciphertext)
+	if err != nil || plaintext != testStr {
+		t.Errorf("got %s %v, wanted %s", plaintext, err, testStr)
+	}
+
+	if _, err := keyring.Decrypt("v1:3:" + strings.SplitN(ciphertext, ":", 3)[2]); err == nil {
+		t.Errorf("unknown key id should have errored")
+	}
+}
+
+func TestKeyringDecryptUnversioned(t *testing.T) {
+	ciphertext, _ := EncryptAES(testStr, []byte(retiredKey))
+
+	keyring := testKeyring(t, "2")
+	if !keyring.NeedsReencrypt(ciphertext) {
+		t.Errorf("unversioned ciphertext should need re-encrypting")
+	}
+	plaintext, err := keyring.Decrypt(ciphertext)
+	if err != nil || plaintext != testStr {
+		t.Errorf("got %s %v, wanted %s", plaintext, err, testStr)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/guregu/dynamo"
+	"github.com/notifi-backend/lambda-src/config"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LockedOutCode is returned when too many attempts with an invalid credential key have been made for a device or
+// from an ip address
+const LockedOutCode = 552
+
+// brute force protection
+const (
+	// failures allowed before attempts are locked out
+	freeFailures = 3
+	// lockout after the first locked out failure, doubling with each failure after it
+	baseLockout = time.Second
+	maxLockout  = 15 * time.Minute
+	// failures are forgotten after a window without any
+	failureWindow = time.Hour
+)
+
+// ErrLockedOut is returned while attempts are locked out
+var ErrLockedOut = errors.New("Too many failed attempts")
+
+// FailureStore stores the counts of failed attempts
+type FailureStore interface {
+	// Failures returns the number of failed attempts of key and when the last was made
+	Failures(ctx context.Context, key string) (int, time.Time, error)
+	// Fail records a failed attempt of key and returns the number of failed attempts within failureWindow
+	Fail(ctx context.Context, key string) (int, error)
+	// Reset forgets the failed attempts of key
+	Reset(ctx context.Context, key string) error
+}
+
+// lockout returns how long attempts are locked out for after count failures
+func lockout(count int) time.Duration {
+	if count < freeFailures {
+		return 0
+	}
+
+	d := baseLockout
+	for i := freeFailures; i < count && d < maxLockout; i++ {
+		d *= 2
+	}
+	if d > maxLockout {
+		return maxLockout
+	}
+	return d
+}
+
+// failureCounter is the count of failed attempts of a key
+type failureCounter struct {
+	Count int
+	Last  time.Time
+}
+
+// MemoryFailureStore keeps failure counts in memory, so they are only enforced per instance
+type MemoryFailureStore struct {
+	mu       sync.Mutex
+	counters map[string]*failureCounter
+}
+
+// NewMemoryFailureStore creates an empty MemoryFailureStore
+func NewMemoryFailureStore() *MemoryFailureStore {
+	return &MemoryFailureStore{counters: map[string]*failureCounter{}}
+}
+
+// Failures returns the failed attempts of key
+func (s *MemoryFailureStore) Failures(_ context.Context, key string) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || time.Since(c.Last) > failureWindow {
+		return 0, time.Time{}, nil
+	}
+	return c.Count, c.Last, nil
+}
+
+// Fail records a failed attempt of key
+func (s *MemoryFailureStore) Fail(_ context.Context, key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.counters) >= maxMemoryBuckets {
+		for k, c := range s.counters {
+			if now.Sub(c.Last) > failureWindow {
+				delete(s.counters, k)
+			}
+		}
+	}
+
+	c, ok := s.counters[key]
+	if !ok || now.Sub(c.Last) > failureWindow {
+		c = &failureCounter{}
+		s.counters[key] = c
+	}
+	c.Count++
+	c.Last = now
+	return c.Count, nil
+}
+
+// Reset forgets the failed attempts of key
+func (s *MemoryFailureStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+// failureItem is a failure count stored in DynamoDB
+type failureItem struct {
+	Key     string    `dynamo:"key,hash"`
+	Count   int       `dynamo:"count"`
+	Updated time.Time `dynamo:"updated_dttm"`
+	Expires time.Time `dynamo:"expires,unixtime"`
+}
+
+// DynamoFailureStore keeps failure counts in the DynamoDB rate limit table
+type DynamoFailureStore struct {
+	db    *dynamo.DB
+	table string
+}
+
+// Failures returns the failed attempts of key
+func (s *DynamoFailureStore) Failures(ctx context.Context, key string) (int, time.Time, error) {
+	var item failureItem
+	err := s.db.Table(s.table).Get("key", "failures:"+key).Consistent(true).OneWithContext(ctx, &item)
+	if errors.Is(err, dynamo.ErrNotFound) || (err == nil && time.Now().After(item.Expires)) {
+		// expired items are only removed eventually
+		return 0, time.Time{}, nil
+	} else if err != nil {
+		return 0, time.Time{}, err
+	}
+	return item.Count, item.Updated, nil
+}
+
+// Fail records a failed attempt of key
+func (s *DynamoFailureStore) Fail(ctx context.Context, key string) (int, error) {
+	table := s.db.Table(s.table)
+	now := time.Now().UTC()
+
+	var item failureItem
+	err := table.Update("key", "failures:"+key).
+		Add("count", 1).
+		Set("updated_dttm", now).
+		Set("expires", now.Add(failureWindow).Unix()).
+		If("attribute_not_exists('key') OR 'expires' > ?", now.Unix()).
+		ValueWithContext(ctx, &item)
+	if dynamo.IsCondCheckFailed(err) {
+		// the previous failures have expired
+		item = failureItem{Key: "failures:" + key, Count: 1, Updated: now, Expires: now.Add(failureWindow)}
+		err = table.Put(item).RunWithContext(ctx)
+	}
+	return item.Count, err
+}
+
+// Reset forgets the failed attempts of key
+func (s *DynamoFailureStore) Reset(ctx context.Context, key string) error {
+	return s.db.Table(s.table).Delete("key", "failures:"+key).RunWithContext(ctx)
+}
+
+// RedisFailureStore keeps failure counts in redis
+type RedisFailureStore struct {
+	client *redis.Client
+}
+
+// Failures returns the failed attempts of key
+func (s *RedisFailureStore) Failures(ctx context.Context, key string) (int, time.Time, error) {
+	values, err := s.client.HMGet(ctx, "failures:"+key, "count", "updated").Result()
+	if err != nil || values[0] == nil || values[1] == nil {
+		return 0, time.Time{}, err
+	}
+
+	count, _ := strconv.Atoi(fmt.Sprint(values[0]))
+	updated, _ := strconv.ParseInt(fmt.Sprint(values[1]), 10, 64)
+	return count, time.UnixMilli(updated), nil
+}
+
+// Fail records a failed attempt of key
+func (s *RedisFailureStore) Fail(ctx context.Context, key string) (int, error) {
+	var count *redis.IntCmd
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.HIncrBy(ctx, "failures:"+key, "count", 1)
+		pipe.HSet(ctx, "failures:"+key, "updated", time.Now().UnixMilli())
+		pipe.PExpire(ctx, "failures:"+key, failureWindow)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// Reset forgets the failed attempts of key
+func (s *RedisFailureStore) Reset(ctx context.Context, key string) error {
+	return s.client.Del(ctx, "failures:"+key).Err()
+}
+
+// NewFailureStore creates the FailureStore kept in the RATE_LIMIT_STORE (memory, dynamo or redis)
+func NewFailureStore(cfg *config.Config, db *DB) (FailureStore, error) {
+	switch cfg.RateLimitStore {
+	case "", "memory":
+		return NewMemoryFailureStore(), nil
+	case "dynamo":
+		return &DynamoFailureStore{db: db.DB, table: cfg.RateLimitTable}, nil
+	case "redis":
+		return &RedisFailureStore{client: redis.NewClient(&redis.Options{
+			Addr: cfg.RedisHost,
+		})}, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit store '%s'", cfg.RateLimitStore)
+}
+
+// failureKeys returns the keys the failed attempts of the device uuid from the ip address are counted under
+func failureKeys(hasher *Hasher, uuid, ip string) []string {
+	return []string{"uuid:" + hasher.Lookup(uuid), "ip:" + ip}
+}
+
+// LockedOut returns how long until attempts of keys, such as a device uuid and an ip address, are no longer locked
+// out. Attempts are let through if the FailureStore is unavailable.
+func (h *Handlers) LockedOut(ctx context.Context, keys ...string) time.Duration {
+	var wait time.Duration
+	for _, key := range keys {
+		count, last, err := h.Failures.Failures(ctx, key)
+		if err != nil {
+			Logger(ctx).WithField("err", err.Error()).Error("problem reading failed attempts")
+			continue
+		}
+		if w := time.Until(last.Add(lockout(count))); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// RecordFailure records a failed attempt with an invalid credential key of keys
+func (h *Handlers) RecordFailure(ctx context.Context, handler string, keys ...string) {
+	credentialKeyFailuresTotal.Inc(handler)
+	for _, key := range keys {
+		count, err := h.Failures.Fail(ctx, key)
+		if err != nil {
+			Logger(ctx).WithField("err", err.Error()).Error("problem recording failed attempt")
+		} else if count == freeFailures {
+			Logger(ctx).WithField("lockout_key", key).Warn("locking out failed attempts")
+		}
+	}
+}
+
+// ResetFailures forgets the failed attempts of key after a successful attempt
+func (h *Handlers) ResetFailures(ctx context.Context, key string) {
+	if err := h.Failures.Reset(ctx, key); err != nil {
+		Logger(ctx).WithField("err", err.Error()).Error("problem resetting failed attempts")
+	}
+}
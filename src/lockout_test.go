@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLockout(t *testing.T) {
+	tests := []struct {
+		count int
+		want  time.Duration
+	}{
+		{0, 0},
+		{freeFailures - 1, 0},
+		{freeFailures, baseLockout},
+		{freeFailures + 1, 2 * baseLockout},
+		{freeFailures + 3, 8 * baseLockout},
+		{freeFailures + 100, maxLockout},
+	}
+	for _, tt := range tests {
+		if got := lockout(tt.count); got != tt.want {
+			t.Errorf("%d failures: got %v, wanted %v", tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryFailureStore(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryFailureStore()
+
+	for i := 1; i <= 2; i++ {
+		if count, _ := s.Fail(ctx, "key"); count != i {
+			t.Errorf("got %d failures, wanted %d", count, i)
+		}
+	}
+	if count, last, _ := s.Failures(ctx, "key"); count != 2 || time.Since(last) > time.Second {
+		t.Errorf("got %d failures at %v, wanted 2 now", count, last)
+	}
+	if count, _, _ := s.Failures(ctx, "other"); count != 0 {
+		t.Errorf("got %d failures of other key, wanted 0", count)
+	}
+
+	s.counters["key"].Last = time.Now().Add(-failureWindow - time.Second)
+	if count, _, _ := s.Failures(ctx, "key"); count != 0 {
+		t.Errorf("got %d failures after the window, wanted 0", count)
+	}
+	if count, _ := s.Fail(ctx, "key"); count != 1 {
+		t.Errorf("got %d failures after the window, wanted 1", count)
+	}
+
+	_ = s.Reset(ctx, "key")
+	if count, _, _ := s.Failures(ctx, "key"); count != 0 {
+		t.Errorf("got %d failures after reset, wanted 0", count)
+	}
+}
+
+func TestLockedOut(t *testing.T) {
+	ctx := context.Background()
+	h := Handlers{Failures: NewMemoryFailureStore()}
+
+	for i := 0; i < freeFailures-1; i++ {
+		h.RecordFailure(ctx, "test", "uuid:a", "ip:1")
+	}
+	if wait := h.LockedOut(ctx, "uuid:a", "ip:1"); wait != 0 {
+		t.Errorf("should not be locked out before %d failures, got %v", freeFailures, wait)
+	}
+
+	h.RecordFailure(ctx, "test", "uuid:b", "ip:1")
+	if wait := h.LockedOut(ctx, "uuid:c", "ip:1"); wait <= 0 || wait > baseLockout {
+		t.Errorf("ip should be locked out for %v, got %v", baseLockout, wait)
+	}
+	if wait := h.LockedOut(ctx, "uuid:a", "ip:2"); wait != 0 {
+		t.Errorf("other ip should not be locked out, got %v", wait)
+	}
+
+	h.ResetFailures(ctx, "ip:1")
+	if wait := h.LockedOut(ctx, "uuid:a", "ip:1"); wait != 0 {
+		t.Errorf("should not be locked out after reset, got %v", wait)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"sync"
+)
+
+// RequestIDHeader is the header the request id is returned in. A request id passed by the client in it is logged as
+// client_request_id.
+const RequestIDHeader = "X-Request-ID"
+
+// maximum length of a request id passed by a client
+const maxRequestIDLen = 128
+
+type loggerKey struct{}
+
+// requestLogger is the logger of a single request that fields are added to as they become known
+type requestLogger struct {
+	mu    sync.Mutex
+	entry *logrus.Entry
+}
+
+// WithLogger returns a copy of ctx carrying a request scoped logger with fields
+func WithLogger(ctx context.Context, fields logrus.Fields) context.Context {
+	return context.WithValue(ctx, loggerKey{}, &requestLogger{entry: logrus.WithFields(fields)})
+}
+
+// AddLogFields adds fields to every following log line of the request scoped logger of ctx
+func AddLogFields(ctx context.Context, fields logrus.Fields) {
+	if l, ok := ctx.Value(loggerKey{}).(*requestLogger); ok {
+		l.mu.Lock()
+		l.entry = l.entry.WithFields(fields)
+		l.mu.Unlock()
+	}
+}
+
+// Logger returns the request scoped logger of ctx or the standard logger if there is none
+func Logger(ctx context.Context) *logrus.Entry {
+	if l, ok := ctx.Value(loggerKey{}).(*requestLogger); ok {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
+
+// RequestID returns the request id of the request scoped logger of ctx
+func RequestID(ctx context.Context) string {
+	id, _ := Logger(ctx).Data["request_id"].(string)
+	return id
+}
+
+// newRequestID returns the lambda request id of ctx or a new random id
+func newRequestID(ctx context.Context) string {
+	if lc, ok := lambdacontext.FromContext(ctx); ok && len(lc.AwsRequestID) > 0 {
+		return lc.AwsRequestID
+	}
+	return uuid.New().String()
+}
+
+// LoggerMiddleware carries a request scoped logger in the request context and returns the request id in the
+// RequestIDHeader response header
+func LoggerMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the request id is always the server's so clients can not make log lines of other requests look like theirs
+		requestID := newRequestID(r.Context())
+		w.Header().Set(RequestIDHeader, requestID)
+
+		fields := logrus.Fields{
+			"request_id":     requestID,
+			"route":          r.URL.Path,
+			"remote-address": r.RemoteAddr,
+		}
+		if clientID := r.Header.Get(RequestIDHeader); len(clientID) > 0 && len(clientID) <= maxRequestIDLen {
+			fields["client_request_id"] = clientID
+		}
+		ctx := WithLogger(r.Context(), fields)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// LogWebsocket carries a request scoped logger in the context of the websocket handler named name and echoes the
+// request id in the RequestIDHeader response header
+func LogWebsocket(name string, handler WebsocketHandler) WebsocketHandler {
+	return func(ctx context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+		requestID := r.RequestContext.RequestID
+		if len(requestID) == 0 {
+			requestID = newRequestID(ctx)
+		}
+
+		ctx = WithLogger(ctx, logrus.Fields{
+			"request_id":    requestID,
+			"route":         name,
+			"connection_id": r.RequestContext.ConnectionID,
+		})
+		resp, err := handler(ctx, r)
+		if resp.Headers == nil {
+			resp.Headers = map[string]string{}
+		}
+		resp.Headers[RequestIDHeader] = requestID
+		return resp, err
+	}
+}
+
+// LogScheduled adds a logger with a request id to the context of a scheduled handler
+func LogScheduled(name string, handler ScheduledHandler) ScheduledHandler {
+	return func(ctx context.Context, e events.CloudWatchEvent) error {
+		ctx = WithLogger(ctx, logrus.Fields{
+			"request_id": newRequestID(ctx),
+			"route":      name,
+		})
+		return handler(ctx, e)
+	}
+}
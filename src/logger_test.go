@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var requestIDTests = []struct {
+	name   string
+	in     string
+	logged bool
+}{
+	{"generated", "", false},
+	{"passed", "abc-123", true},
+	{"too long", string(make([]byte, maxRequestIDLen+1)), false},
+}
+
+func TestLoggerMiddleware(t *testing.T) {
+	for _, tt := range requestIDTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var loggedID string
+			var clientID interface{}
+			handler := LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				AddLogFields(r.Context(), logrus.Fields{"credentials": "foo"})
+				loggedID = RequestID(r.Context())
+				clientID = Logger(r.Context()).Data["client_request_id"]
+				if Logger(r.Context()).Data["credentials"] != "foo" {
+					t.Errorf("added log fields should be on the request logger")
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api", nil)
+			if len(tt.in) > 0 {
+				req.Header.Set(RequestIDHeader, tt.in)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			responseID := w.Header().Get(RequestIDHeader)
+			if len(responseID) == 0 || responseID != loggedID || responseID == tt.in {
+				t.Errorf("response id '%s' should equal the generated logged id '%s'", responseID, loggedID)
+			}
+			if (clientID == tt.in) != tt.logged {
+				t.Errorf("got client request id %v, wanted logged %v", clientID, tt.logged)
+			}
+		})
+	}
+}
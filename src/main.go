@@ -2,11 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/awslabs/aws-lambda-go-api-proxy/chi"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/notifi-backend/lambda-src/config"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"os"
@@ -17,33 +21,103 @@ var chiLambda *chiadapter.ChiLambda
 func init() {
 	logrus.SetFormatter(&logrus.JSONFormatter{})
 	logrus.SetOutput(os.Stdout)
+}
 
+// NewRouter routes the http requests to h Handlers
+func NewRouter(h *Handlers) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(LoggerMiddleware)
 	r.Use(middleware.Recoverer)
-	r.HandleFunc("/code", HandleCode)
-	r.HandleFunc("/api", HandleApi)
+	r.Use(TracingMiddleware)
+	r.Use(MetricsMiddleware)
+	r.HandleFunc("/code", h.HandleCode)
+	r.HandleFunc("/api", h.HandleApi)
+	r.HandleFunc("/stats", h.HandleStats)
+	r.Post("/status", h.HandleStatus)
+	r.HandleFunc("/key", h.HandlePublicKey)
+	r.HandleFunc("/tokens", h.HandleTokens)
+	r.Post("/delete", h.HandleDelete)
+	r.Post("/export", h.HandleExport)
+	r.Get("/healthz", h.HandleHealthz)
+	r.Get("/readyz", h.HandleReadyz)
 	r.HandleFunc("/ws", func(writer http.ResponseWriter, req *http.Request) {
-		http.Redirect(writer, req, "https://"+os.Getenv("WS_HOST"), http.StatusMovedPermanently)
+		http.Redirect(writer, req, "https://"+h.Config.WSHost, http.StatusMovedPermanently)
 	})
-	chiLambda = chiadapter.New(r)
+	return r
 }
 
 func main() {
-	switch arg := os.Args[1]; arg {
-	case "http":
+	arg := os.Args[1]
+
+	cfg, err := config.Load()
+	if err == nil {
+		err = cfg.Validate(arg)
+	}
+	if err != nil {
+		logrus.Fatalf("Invalid configuration: %s", err.Error())
+	}
+
+	h, err := NewHandlers(cfg)
+	if err != nil {
+		logrus.Fatalf("Invalid configuration: %s", err.Error())
+	}
+
+	EmitEMF = arg != config.ModeServer && arg != config.ModeAdmin
+	if arg == config.ModeAdmin {
+		// keep the logs out of the command output
+		logrus.SetOutput(os.Stderr)
+	}
+	if err := InitTracing(context.Background()); err != nil {
+		logrus.Errorf("Problem setting up tracing: %s", err.Error())
+	}
+
+	switch arg {
+	case config.ModeServer:
+		Serve(h)
+	case config.ModeHTTP:
+		// lambda responses are buffered and capped so exports can not be streamed
+		h.ExportLimit = maxLambdaExportBytes
+		chiLambda = chiadapter.New(NewRouter(h))
 		lambda.Start(HttpHandler)
-	case "connect":
-		lambda.Start(HandleConnect)
-	case "message":
-		lambda.Start(HandleMessage)
-	case "disconnect":
-		lambda.Start(HandleDisconnect)
+	case config.ModeConnect:
+		lambda.Start(LogWebsocket("connect", TraceWebsocket("connect", InstrumentWebsocket("connect", h.HandleConnect))))
+	case config.ModeMessage:
+		lambda.Start(LogWebsocket("message", TraceWebsocket("message", InstrumentWebsocket("message", h.HandleMessage))))
+	case config.ModeDisconnect:
+		lambda.Start(LogWebsocket("disconnect", TraceWebsocket("disconnect", InstrumentWebsocket("disconnect", h.HandleDisconnect))))
+	case config.ModeEscalate:
+		lambda.Start(LogScheduled("escalate", TraceScheduled("escalate", InstrumentScheduled("escalate", h.HandleEscalate))))
+	case config.ModeReencrypt:
+		if err := h.HandleReencrypt(context.Background()); err != nil {
+			logrus.Fatalf("Problem re-encrypting: %s", err.Error())
+		}
+	case config.ModeAdmin:
+		if err := h.Admin(context.Background(), os.Args[2:], os.Stdout); err != nil {
+			if errors.Is(err, ErrAdminUsage) {
+				fmt.Fprint(os.Stderr, AdminUsage)
+			}
+			logrus.Fatalf("Problem running admin command: %s", err.Error())
+		}
 	default:
 		panic("invalid lambda")
 	}
 }
 
+// Serve runs the http handlers as a standalone server on PORT with prometheus metrics on /metrics
+func Serve(h *Handlers) {
+	router := NewRouter(h)
+	router.Handle("/metrics", promhttp.Handler())
+
+	addr := ":" + h.Config.Port
+	if addr == ":" {
+		addr = ":8080"
+	}
+	logrus.Infof("Serving on %s", addr)
+	logrus.Fatal(http.ListenAndServe(addr, router))
+}
+
 func HttpHandler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	// If no name is provided in the HTTP request body, throw an error
+	defer FlushTraces(ctx)
 	return chiLambda.ProxyWithContext(ctx, req)
 }
@@ -3,43 +3,72 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/sirupsen/logrus"
 	"net/http"
-	"os"
+	"strings"
 )
 
 const MaxWSSizeKB = 32
 
-func HandleMessage(_ context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
-	db, err := GetDB()
-	if err != nil {
-		return WriteError(err, http.StatusInternalServerError)
+// StatsMessage is the websocket message used to request the usage stats of the connected credentials
+const StatsMessage = "stats"
+
+// TokensMessage is the websocket message used to list the send-only tokens of the connected credentials
+const TokensMessage = "tokens"
+
+// TokenMessage structure of the websocket message used to create or revoke a send-only token
+type TokenMessage struct {
+	Action string `json:"action"`
+	ID     string `json:"id"`
+	TokenRequest
+}
+
+// decryptBacklog decrypts the queued notifications. Any that can not be decrypted are logged and skipped so they do
+// not block the rest of the backlog on every reconnect.
+func decryptBacklog(ctx context.Context, notifications []Notification, envelope *Envelope) []Notification {
+	decrypted := make([]Notification, 0, len(notifications))
+	for _, n := range notifications {
+		if err := n.Decrypt(ctx, envelope); err != nil {
+			decryptFailuresTotal.Inc("message")
+			Logger(ctx).WithFields(logrus.Fields{
+				"uuid": n.UUID,
+				"err":  err.Error(),
+			}).Error("problem decrypting queued notification")
+			continue
+		}
+		decrypted = append(decrypted, n)
 	}
+	return decrypted
+}
 
+func (h *Handlers) HandleMessage(ctx context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+	db := h.DB
 	var user User
-	err = db.Table(UserTable).Get("connection_id", r.RequestContext.ConnectionID).Index("connection_id-index").One(&user)
+	err := db.Users().Get("connection_id", r.RequestContext.ConnectionID).Index("connection_id-index").OneWithContext(ctx, &user)
 	if err != nil {
-		return WriteError(err, http.StatusInternalServerError)
+		return WriteError(ctx, err, http.StatusInternalServerError)
 	}
+	AddLogFields(ctx, logrus.Fields{"credentials": user.Credentials})
 
 	if r.Body == "." {
 		var notifications []Notification
-		err = db.Table(NotificationTable).Get("credentials", user.Credentials).Index("credentials-index").All(&notifications)
+		err = db.Notifications().Get("credentials", user.Credentials).Index("credentials-index").AllWithContext(ctx, &notifications)
 		if err != nil {
-			return WriteError(err, http.StatusInternalServerError)
+			return WriteError(ctx, err, http.StatusInternalServerError)
 		}
 
+		backlogSize.Observe(float64(len(notifications)))
+		notifications = decryptBacklog(ctx, notifications, h.Envelope)
 		if len(notifications) > 0 {
 			var notificationChunks [][]Notification
 			var notificationChunk []Notification
 
-			// decrypt notifications and chunk them into MaxWSSizeKB
+			// chunk notifications into MaxWSSizeKB
 			for i := range notifications {
 				var notification = notifications[i]
-				var encryptionKey = []byte(os.Getenv("ENCRYPTION_KEY"))
-				if err := notification.Decrypt(encryptionKey); err != nil {
-					return WriteError(err, http.StatusInternalServerError)
-				}
 
 				chunkSizeBytes, _ := json.Marshal(notificationChunk)
 				chunkSize := len(chunkSizeBytes)
@@ -56,12 +85,12 @@ func HandleMessage(_ context.Context, r events.APIGatewayWebsocketProxyRequest)
 			for i := range notificationChunks {
 				notificationsBytes, err := json.Marshal(notificationChunks[i])
 				if err != nil {
-					return WriteError(err, http.StatusInternalServerError)
+					return WriteError(ctx, err, http.StatusInternalServerError)
 				}
 
-				err = SendWsMessage(user.ConnectionID, notificationsBytes)
+				err = SendWsMessage(ctx, h.Config, user.ConnectionID, notificationsBytes)
 				if err != nil {
-					return WriteError(err, http.StatusInternalServerError)
+					return WriteError(ctx, err, http.StatusInternalServerError)
 				}
 			}
 		}
@@ -69,16 +98,90 @@ func HandleMessage(_ context.Context, r events.APIGatewayWebsocketProxyRequest)
 		return WriteEmptySuccess()
 	}
 
+	if r.Body == StatsMessage {
+		stats, err := GetUsageStats(ctx, db, user.Credentials)
+		if err != nil {
+			return WriteError(ctx, err, http.StatusInternalServerError)
+		}
+
+		statsBytes, err := json.Marshal(map[string]UsageStats{"stats": stats})
+		if err != nil {
+			return WriteError(ctx, err, http.StatusInternalServerError)
+		}
+
+		if err := SendWsMessage(ctx, h.Config, user.ConnectionID, statsBytes); err != nil {
+			return WriteError(ctx, err, http.StatusInternalServerError)
+		}
+		return WriteEmptySuccess()
+	}
+
+	if r.Body == TokensMessage || strings.HasPrefix(r.Body, "{") {
+		if !db.TokensEnabled() {
+			return WriteError(ctx, errors.New("Tokens are not enabled!"), http.StatusNotFound)
+		}
+		resp, err := h.handleTokenMessage(ctx, user, r.Body)
+		if err != nil {
+			return WriteError(ctx, err, http.StatusBadRequest)
+		}
+
+		respBytes, err := json.Marshal(resp)
+		if err != nil {
+			return WriteError(ctx, err, http.StatusInternalServerError)
+		}
+		if err := SendWsMessage(ctx, h.Config, user.ConnectionID, respBytes); err != nil {
+			return WriteError(ctx, err, http.StatusInternalServerError)
+		}
+		return WriteEmptySuccess()
+	}
+
 	var uuids []string
 	if err := json.Unmarshal([]byte(r.Body), &uuids); err != nil {
-		return WriteError(err, http.StatusBadRequest)
+		return WriteError(ctx, err, http.StatusBadRequest)
 	}
 
 	wtx := db.WriteTx()
-	t := db.Table(NotificationTable)
+	t := db.Notifications()
 	for _, UUID := range uuids {
 		wtx.Delete(t.Delete("uuid", UUID).If("'uuid' = ?", UUID).If("'credentials' = ?", user.Credentials))
 	}
-	_ = wtx.Run()
+	_ = wtx.RunWithContext(ctx)
+
+	if db.EscalationEnabled() {
+		if err := AcknowledgeEscalations(ctx, db, user.Credentials, uuids); err != nil {
+			Logger(ctx).WithField("err", err.Error()).Error("problem acknowledging escalations")
+		}
+	}
 	return WriteEmptySuccess()
 }
+
+// handleTokenMessage lists the tokens of user or creates or revokes one as requested by the TokenMessage body
+func (h *Handlers) handleTokenMessage(ctx context.Context, user User, body string) (interface{}, error) {
+	var msg TokenMessage
+	if body != TokensMessage {
+		if err := json.Unmarshal([]byte(body), &msg); err != nil {
+			return nil, err
+		}
+	}
+
+	switch msg.Action {
+	case "", "list":
+	case "create":
+		token, err := CreateToken(ctx, h.DB, user.Credentials, msg.TokenRequest)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]Token{"token": token}, nil
+	case "revoke":
+		if err := RevokeToken(ctx, h.DB, user.Credentials, msg.ID); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Unknown token action '%s'!", msg.Action)
+	}
+
+	tokens, err := ListTokens(ctx, h.DB, user.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]Token{"tokens": tokens}, nil
+}
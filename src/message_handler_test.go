@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDecryptBacklogSkipsUndecryptable(t *testing.T) {
+	ctx := context.Background()
+	envelope := testEnvelope(t, "2")
+
+	good := Notification{UUID: "good", Credentials: "credentials", Title: "title"}
+	if err := good.Encrypt(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	bad := Notification{UUID: "bad", Credentials: "credentials", Title: "title"}
+	if err := bad.Encrypt(ctx, envelope); err != nil {
+		t.Fatal(err)
+	}
+	// the content no longer matches the notification it was bound to
+	bad.Credentials = "other"
+
+	got := decryptBacklog(ctx, []Notification{bad, good}, envelope)
+	if len(got) != 1 || got[0].UUID != "good" || got[0].Title != "title" {
+		t.Errorf("got %+v, wanted only the decrypted good notification", got)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsNamespace is the prometheus namespace and CloudWatch namespace of all metrics
+const MetricsNamespace = "notifi"
+
+// EmitEMF writes metrics as CloudWatch embedded metric format log lines instead of collecting them for prometheus
+var EmitEMF = false
+
+var emfMu sync.Mutex
+
+var (
+	requestsTotal = NewCounter("requests_total",
+		"Number of requests per handler.", "handler", "code")
+	requestDuration = NewHistogram("request_duration_seconds",
+		"Latency of requests per handler.", "handler")
+	validationFailuresTotal = NewCounter("validation_failures_total",
+		"Number of notifications that failed validation per reason.", "reason")
+	wsSendFailuresTotal = NewCounter("websocket_send_failures_total",
+		"Number of messages that failed to send over a websocket.")
+	firebaseSendFailuresTotal = NewCounter("firebase_send_failures_total",
+		"Number of firebase messages that failed to send.")
+	backlogSize = NewHistogram("backlog_size",
+		"Number of queued notifications sent to a client when it requests its backlog.")
+	serverKeyRequestsTotal = NewCounter("server_key_requests_total",
+		"Number of requests authenticated by a server key per handler and key id.", "handler", "key_id")
+	credentialKeyFailuresTotal = NewCounter("credential_key_failures_total",
+		"Number of attempts with an invalid credential key per handler.", "handler")
+	decryptFailuresTotal = NewCounter("decrypt_failures_total",
+		"Number of stored notifications that could not be decrypted per handler.", "handler")
+	dynamoDuration = NewHistogram("dynamodb_duration_seconds",
+		"Latency of DynamoDB calls per operation.", "operation")
+)
+
+// Counter is a metric that only increases
+type Counter struct {
+	name   string
+	labels []string
+	vec    *prometheus.CounterVec
+}
+
+// NewCounter creates and registers a Counter with the label names labels
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		name:   name,
+		labels: labels,
+		vec: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Name:      name,
+			Help:      help,
+		}, labels),
+	}
+	prometheus.MustRegister(c.vec)
+	return c
+}
+
+// Inc increments c Counter for the label values
+func (c *Counter) Inc(values ...string) {
+	if EmitEMF {
+		writeEMF(c.name, 1, "Count", c.labels, values)
+		return
+	}
+	c.vec.WithLabelValues(values...).Inc()
+}
+
+// Histogram is a metric of observed values
+type Histogram struct {
+	name   string
+	unit   string
+	labels []string
+	vec    *prometheus.HistogramVec
+}
+
+// NewHistogram creates and registers a Histogram with the label names labels. Names ending in _seconds are
+// observed in seconds.
+func NewHistogram(name, help string, labels ...string) *Histogram {
+	h := &Histogram{
+		name:   name,
+		unit:   "Count",
+		labels: labels,
+		vec: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: MetricsNamespace,
+			Name:      name,
+			Help:      help,
+		}, labels),
+	}
+	if strings.HasSuffix(name, "_seconds") {
+		h.unit = "Seconds"
+	}
+	prometheus.MustRegister(h.vec)
+	return h
+}
+
+// Observe adds value to h Histogram for the label values
+func (h *Histogram) Observe(value float64, values ...string) {
+	if EmitEMF {
+		writeEMF(h.name, value, h.unit, h.labels, values)
+		return
+	}
+	h.vec.WithLabelValues(values...).Observe(value)
+}
+
+// writeEMF writes a single metric value as a CloudWatch embedded metric format log line
+func writeEMF(name string, value float64, unit string, labels, values []string) {
+	line := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixMilli(),
+			"CloudWatchMetrics": []map[string]interface{}{{
+				"Namespace":  MetricsNamespace,
+				"Dimensions": [][]string{append([]string{}, labels...)},
+				"Metrics": []map[string]string{{
+					"Name": name,
+					"Unit": unit,
+				}},
+			}},
+		},
+		name: value,
+	}
+	for i, label := range labels {
+		if i < len(values) {
+			line[label] = values[i]
+		}
+	}
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		return
+	}
+
+	emfMu.Lock()
+	defer emfMu.Unlock()
+	_, _ = fmt.Fprintln(os.Stdout, string(b))
+}
+
+// MetricsMiddleware records the count and latency of requests per route
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		handler := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && len(rctx.RoutePattern()) > 0 {
+			handler = rctx.RoutePattern()
+		}
+		code := ww.Status()
+		if code == 0 {
+			code = http.StatusOK
+		}
+		requestsTotal.Inc(handler, strconv.Itoa(code))
+		requestDuration.Observe(time.Since(start).Seconds(), handler)
+	})
+}
+
+// WebsocketHandler is a lambda handler of API Gateway websocket events
+type WebsocketHandler func(context.Context, events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error)
+
+// InstrumentWebsocket records the count and latency of requests to the websocket handler named name
+func InstrumentWebsocket(name string, handler WebsocketHandler) WebsocketHandler {
+	return func(ctx context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+		start := time.Now()
+		resp, err := handler(ctx, r)
+		requestsTotal.Inc(name, strconv.Itoa(resp.StatusCode))
+		requestDuration.Observe(time.Since(start).Seconds(), name)
+		return resp, err
+	}
+}
+
+// ScheduledHandler is a lambda handler of scheduled CloudWatch events
+type ScheduledHandler func(context.Context, events.CloudWatchEvent) error
+
+// InstrumentScheduled records the run count and duration of a scheduled handler under name, counting errors as 500s
+func InstrumentScheduled(name string, handler ScheduledHandler) ScheduledHandler {
+	return func(ctx context.Context, e events.CloudWatchEvent) error {
+		start := time.Now()
+		err := handler(ctx, e)
+		code := http.StatusOK
+		if err != nil {
+			code = http.StatusInternalServerError
+		}
+		requestsTotal.Inc(name, strconv.Itoa(code))
+		requestDuration.Observe(time.Since(start).Seconds(), name)
+		return err
+	}
+}
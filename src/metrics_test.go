@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsMiddleware(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(MetricsMiddleware)
+	r.HandleFunc("/teapot", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	before := testutil.ToFloat64(requestsTotal.vec.WithLabelValues("/teapot", "418"))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/teapot", nil))
+	after := testutil.ToFloat64(requestsTotal.vec.WithLabelValues("/teapot", "418"))
+	if after-before != 1 {
+		t.Errorf("got %v requests, wanted 1", after-before)
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"firebase.google.com/go/v4/messaging"
 	"fmt"
 	"github.com/google/uuid"
-	"github.com/guregu/dynamo"
+	"github.com/notifi-backend/lambda-src/config"
+	"github.com/notifi-backend/lambda-src/sealedbox"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
-	"os"
 	"reflect"
 	"strconv"
 	"strings"
@@ -16,8 +21,6 @@ import (
 
 const MaxNotificationSizeKB = 15
 
-var NotificationTable = os.Getenv("NOTIFICATION_TABLE_NAME")
-
 // Notification structure
 type Notification struct {
 	Credentials string `json:"-" dynamo:"credentials,hash"`
@@ -27,6 +30,26 @@ type Notification struct {
 	Time        string `json:"time" dynamo:"time"`
 	Title       string `json:"title" dynamo:"title"`
 	UUID        string `json:"UUID" dynamo:"uuid,hash"`
+	Escalate    bool   `json:"escalate,omitempty" dynamo:"escalate,omitempty"`
+	DedupeKey   string `json:"dedupe_key,omitempty" dynamo:"dedupe_key,omitempty" schema:"dedupe_key"`
+	Repeats     int    `json:"repeats,omitempty" dynamo:"repeats,omitempty" schema:"-"`
+
+	// topic a token can be restricted to sending
+	Topic string `json:"topic,omitempty" dynamo:"topic,omitempty"`
+
+	// base64 sealed box of the content encrypted to the public key of the user
+	Ciphertext string `json:"ciphertext,omitempty" dynamo:"ciphertext,omitempty"`
+
+	// data key the content is encrypted with, wrapped by the master key of a KeyProvider
+	DataKey string `json:"-" dynamo:"data_key,omitempty" schema:"-"`
+
+	// seconds a dedupe key collapses repeated notifications, only read from the request
+	DedupeWindow int `json:"-" dynamo:"-" schema:"dedupe_window"`
+
+	// escalation policy only read from the request
+	EscalateInterval int    `json:"-" dynamo:"-" schema:"escalate_interval"`
+	EscalateMax      int    `json:"-" dynamo:"-" schema:"escalate_max"`
+	EscalateTo       string `json:"-" dynamo:"-" schema:"escalate_to"`
 }
 
 // size restrictions of notifications
@@ -34,119 +57,315 @@ const (
 	maxTitle      = 1000
 	maxMessage    = 10000
 	maxImageBytes = 2000000 // 2MB
+	maxDedupeKey  = 255
+
+	maxCiphertextBytes = MaxNotificationSizeKB * 1024
+)
+
+// dedupe window restrictions
+const (
+	defaultDedupeWindow = 1 * time.Hour
+	maxDedupeWindow     = 24 * time.Hour
 )
 
 const notificationTimeLayout = "2006-01-02 15:04:05"
 
+// boundCiphertextPrefix marks content encrypted with the additionalData of its notification and field
+const boundCiphertextPrefix = "aad:"
+
+// sealedNotificationTitle is pushed in place of the title of end-to-end encrypted notifications
+const sealedNotificationTitle = "New notification"
+
 // Store will store n Notification in the database after encrypting the content
-func (n *Notification) Store(db *dynamo.DB, encryptionKey []byte) (err error) {
-	n.Title, err = EncryptAES(n.Title, encryptionKey)
-	if err != nil {
-		return
+func (n *Notification) Store(ctx context.Context, db *DB, envelope *Envelope) error {
+	if err := n.Encrypt(ctx, envelope); err != nil {
+		return err
 	}
+	return db.Notifications().Put(&n).RunWithContext(ctx)
+}
 
-	n.Message, err = EncryptAES(n.Message, encryptionKey)
-	if err != nil {
-		return
+// Encrypt encrypts the content of n Notification with a new data key from envelope. End-to-end encrypted
+// notifications have no other content so they are stored without a data key.
+func (n *Notification) Encrypt(ctx context.Context, envelope *Envelope) error {
+	if n.IsSealed() {
+		n.DataKey = ""
+		return nil
 	}
 
-	n.Image, err = EncryptAES(n.Image, encryptionKey)
+	key, wrapped, err := envelope.NewDataKey(ctx)
 	if err != nil {
-		return
+		return err
 	}
 
-	n.Link, err = EncryptAES(n.Link, encryptionKey)
-	if err != nil {
-		return
+	for _, field := range n.encryptedFields() {
+		ciphertext, err := EncryptAESWithAAD(*field.value, key, n.additionalData(field.name))
+		if err != nil {
+			return err
+		}
+		if len(ciphertext) > 0 {
+			ciphertext = boundCiphertextPrefix + ciphertext
+		}
+		*field.value = ciphertext
+	}
+	n.DataKey = wrapped
+	return nil
+}
+
+// encryptedField is a named field of the content of a Notification that is stored encrypted
+type encryptedField struct {
+	name  string
+	value *string
+}
+
+// encryptedFields returns the content of n Notification that is stored encrypted
+func (n *Notification) encryptedFields() []encryptedField {
+	return []encryptedField{
+		{"title", &n.Title},
+		{"message", &n.Message},
+		{"image", &n.Image},
+		{"link", &n.Link},
 	}
+}
 
-	return db.Table(NotificationTable).Put(&n).Run()
+// additionalData returns the additional data that binds the ciphertext of field to n Notification, so it can not be
+// moved to another field, notification or user
+func (n *Notification) additionalData(field string) []byte {
+	return []byte(n.UUID + "|" + n.Credentials + "|" + field)
 }
 
 // Validate runs validation on n Notification
-func (n *Notification) Validate() error {
+func (n *Notification) Validate(ctx context.Context) error {
 	if len(n.Credentials) == 0 {
-		return errors.New("You must specify Credentials!")
+		return NewValidationError(CredentialsReason, "You must specify Credentials!")
 	}
 
 	if n.Credentials == "<credentials>" {
-		return errors.New(`You have not set your personal 
+		return NewValidationError(CredentialsReason, `You have not set your personal 
 		credentials given to you by the notifi app! 
 		You instead used the placeholder '<credentials>'`)
 	}
 
+	if len(n.Ciphertext) > 0 {
+		if err := n.validateCiphertext(); err != nil {
+			return err
+		}
+	} else if err := n.validateContent(ctx); err != nil {
+		return err
+	}
+
+	if !IsValidTopic(n.Topic) {
+		return NewValidationError(TopicReason, fmt.Sprintf("Topic must be at most %d letters, numbers, '_', '.' or '-'!", maxTopic))
+	}
+
+	if len(n.DedupeKey) > maxDedupeKey {
+		return NewValidationError(DedupeReason, "You must enter a shorter dedupe key!")
+	}
+
+	if n.DedupeWindow < 0 || n.DedupeWindow > int(maxDedupeWindow/time.Second) {
+		return NewValidationError(DedupeReason, fmt.Sprintf("Dedupe window must be between 0 and %d seconds!", int(maxDedupeWindow.Seconds())))
+	}
+
+	if n.Escalate {
+		if n.EscalateInterval > int(maxEscalationInterval/time.Second) {
+			// checked in seconds as the interval would overflow a time.Duration
+			return NewValidationError(EscalationReason, fmt.Sprintf("Escalation interval must be at most %d seconds!", int(maxEscalationInterval.Seconds())))
+		}
+		if err := n.EscalationPolicy().Validate(); err != nil {
+			return err
+		}
+	}
+
+	sizeKB := n.SizeKB()
+	if sizeKB > MaxNotificationSizeKB {
+		return NewValidationError(SizeReason, fmt.Sprintf("Notification too large (%dkb) should be less than %dkb", sizeKB, MaxNotificationSizeKB))
+	}
+
+	return nil
+}
+
+// validateContent runs validation on the plaintext content of n Notification
+func (n *Notification) validateContent(ctx context.Context) error {
 	if len(n.Title) == 0 {
-		return errors.New("You must enter a title!")
+		return NewValidationError(TitleReason, "You must enter a title!")
 	} else if len(n.Title) > maxTitle {
-		return errors.New("You must enter a shorter title!")
+		return NewValidationError(TitleReason, "You must enter a shorter title!")
 	}
 
 	if len(n.Message) > maxMessage {
-		return errors.New("You must enter a shorter message!")
+		return NewValidationError(MessageReason, "You must enter a shorter message!")
 	}
 
 	if !IsValidURL(n.Link) {
-		return errors.New("Invalid URL for link!")
+		return NewValidationError(LinkReason, "Invalid URL for link!")
 	}
 
 	if !IsValidURL(n.Image) {
-		return errors.New("Invalid URL for image!")
+		return NewValidationError(ImageReason, "Invalid URL for image!")
 	}
 
 	if len(n.Image) > 0 {
 		if strings.Contains(n.Image, "http://") {
-			return errors.New("Image host must use https!")
+			return NewValidationError(ImageReason, "Image host must use https!")
 		}
 
-		timeout := 500 * time.Millisecond
-		client := http.Client{
-			Timeout: timeout,
-		}
-		resp, err := client.Head(n.Image)
+		contentLen, err := imageContentLength(ctx, n.Image)
 		if err != nil {
 			n.Image = "" // remove image reference
-		} else {
-			contentLen, err := strconv.Atoi(resp.Header.Get("Content-Length"))
-			if err != nil {
-				n.Image = "" // remove image reference
-			}
+		}
+
+		if contentLen > maxImageBytes {
+			return NewValidationError(ImageReason, fmt.Sprintf("Image too large (%d) should be less than %d", contentLen, maxImageBytes))
+		}
+	}
+	return nil
+}
+
+// validateCiphertext runs validation on the end-to-end encrypted content of n Notification, which can only be size
+// checked
+func (n *Notification) validateCiphertext() error {
+	if len(n.Title) > 0 || len(n.Message) > 0 || len(n.Link) > 0 || len(n.Image) > 0 {
+		return NewValidationError(CiphertextReason, "End-to-end encrypted notifications must only contain ciphertext!")
+	}
+
+	box, err := base64.StdEncoding.DecodeString(n.Ciphertext)
+	if err != nil {
+		return NewValidationError(CiphertextReason, "Ciphertext must be base64!")
+	}
+	if len(box) < sealedbox.Overhead {
+		return NewValidationError(CiphertextReason, "Ciphertext must be a sealed box!")
+	} else if len(box) > maxCiphertextBytes {
+		return NewValidationError(CiphertextReason, fmt.Sprintf("Ciphertext too large (%d) should be less than %d", len(box), maxCiphertextBytes))
+	}
+
+	if n.Escalate && len(n.EscalateTo) > 0 {
+		return NewValidationError(EscalationReason, "End-to-end encrypted notifications can not be escalated to other credentials!")
+	}
+	return nil
+}
 
-			if contentLen > maxImageBytes {
-				return fmt.Errorf("Image too large (%d) should be less than %d", contentLen, maxImageBytes)
+// imageContentLength requests the content length of the image at url
+func imageContentLength(ctx context.Context, url string) (contentLen int, err error) {
+	ctx, span := tracer.Start(ctx, "imageContentLength", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { EndSpan(span, err) }()
+
+	timeout := 500 * time.Millisecond
+	client := http.Client{
+		Timeout: timeout,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	return strconv.Atoi(resp.Header.Get("Content-Length"))
+}
+
+// Decrypt decrypts n Notification with its data key. Notifications stored before envelope encryption are decrypted
+// with the Keyring of envelope. Nothing is decrypted if any of the content can not be.
+func (n *Notification) Decrypt(ctx context.Context, envelope *Envelope) error {
+	if n.IsSealed() {
+		// any data key was stored by older versions and is not needed
+		n.DataKey = ""
+		return nil
+	}
+
+	decrypt := func(_, str string) (string, error) {
+		return envelope.Keyring.Decrypt(str)
+	}
+	if len(n.DataKey) > 0 {
+		key, err := envelope.DataKey(ctx, n.DataKey)
+		if err != nil {
+			return fmt.Errorf("unable to decrypt notification %s: %w", n.UUID, err)
+		}
+		decrypt = func(field, str string) (string, error) {
+			if bound, ok := strings.CutPrefix(str, boundCiphertextPrefix); ok {
+				return DecryptAESWithAAD(bound, key, n.additionalData(field))
 			}
+			// encrypted before the content was bound to the notification
+			return DecryptAES(str, key)
 		}
 	}
 
-	sizeKB := n.SizeKB()
-	if sizeKB > MaxNotificationSizeKB {
-		return fmt.Errorf("Notification too large (%dkb) should be less than %dkb", sizeKB, MaxNotificationSizeKB)
+	fields := n.encryptedFields()
+	plaintexts := make([]string, len(fields))
+	for i, field := range fields {
+		plaintext, err := decrypt(field.name, *field.value)
+		if err != nil {
+			return fmt.Errorf("unable to decrypt %s of notification %s: %w", field.name, n.UUID, err)
+		}
+		plaintexts[i] = plaintext
 	}
 
+	for i, field := range fields {
+		*field.value = plaintexts[i]
+	}
+	n.DataKey = ""
 	return nil
 }
 
-// Decrypt decrypts n Notification
-func (n *Notification) Decrypt(encryptionKey []byte) error {
-	title, err := DecryptAES(n.Title, encryptionKey)
-	if err == nil {
-		n.Title = title
+// NeedsReencrypt returns whether n Notification was stored before envelope encryption or before its content was bound
+// to it
+func (n *Notification) NeedsReencrypt() bool {
+	if n.IsSealed() {
+		return len(n.DataKey) > 0
+	}
+	if len(n.DataKey) == 0 {
+		return true
+	}
+	for _, field := range n.encryptedFields() {
+		if len(*field.value) > 0 && !strings.HasPrefix(*field.value, boundCiphertextPrefix) {
+			return true
+		}
 	}
+	return false
+}
 
-	message, err := DecryptAES(n.Message, encryptionKey)
-	if err == nil {
-		n.Message = message
+// IsSealed returns whether n Notification is end-to-end encrypted, so its only content is the Ciphertext
+func (n *Notification) IsSealed() bool {
+	if len(n.Ciphertext) == 0 {
+		return false
+	}
+	for _, field := range n.encryptedFields() {
+		if len(*field.value) > 0 {
+			return false
+		}
 	}
+	return true
+}
+
+// sealedContent structure of the content of a Notification encrypted end-to-end
+type sealedContent struct {
+	Title   string `json:"title"`
+	Message string `json:"message"`
+	Image   string `json:"image"`
+	Link    string `json:"link"`
+}
 
-	image, err := DecryptAES(n.Image, encryptionKey)
-	if err == nil {
-		n.Image = image
+// Seal encrypts the content of n Notification end-to-end to the base64 X25519 publicKey of the user, so only the
+// ciphertext is stored and forwarded
+func (n *Notification) Seal(publicKey string) error {
+	key, err := sealedbox.ParsePublicKey(publicKey)
+	if err != nil {
+		return err
 	}
 
-	link, err := DecryptAES(n.Link, encryptionKey)
-	if err == nil {
-		n.Link = link
+	content, err := json.Marshal(sealedContent{Title: n.Title, Message: n.Message, Image: n.Image, Link: n.Link})
+	if err != nil {
+		return err
+	}
+	box, err := sealedbox.Seal(key, content)
+	if err != nil {
+		return err
 	}
-	return err
+
+	n.Ciphertext = base64.StdEncoding.EncodeToString(box)
+	n.Title, n.Message, n.Image, n.Link = "", "", "", ""
+	return nil
 }
 
 // Init set UUID and time
@@ -156,6 +375,94 @@ func (n *Notification) Init() {
 	n.UUID = uuid.New().String()
 }
 
+// Deduplicate records n Notification as sent under its dedupe key in store. If another notification was sent under
+// the key within the dedupe window, n Notification takes its place and counts it as a repeat.
+func (n *Notification) Deduplicate(ctx context.Context, store DedupeStore) error {
+	if len(n.DedupeKey) == 0 {
+		return nil
+	}
+
+	uuid, repeats, err := store.Dedupe(ctx, n.Credentials+":"+n.DedupeKey, n.UUID, n.DedupeWindowDuration())
+	if err != nil {
+		return err
+	}
+	n.UUID = uuid
+	n.Repeats = repeats
+	return nil
+}
+
+// DedupeWindowDuration returns how long the dedupe key of n Notification collapses repeated notifications
+func (n *Notification) DedupeWindowDuration() time.Duration {
+	if n.DedupeWindow > 0 {
+		return time.Duration(n.DedupeWindow) * time.Second
+	}
+	return defaultDedupeWindow
+}
+
+// EscalationPolicy returns the requested escalation policy of n Notification
+func (n *Notification) EscalationPolicy() EscalationPolicy {
+	policy := EscalationPolicy{
+		Interval:             defaultEscalationInterval,
+		MaxAttempts:          defaultEscalationAttempts,
+		SecondaryCredentials: n.EscalateTo,
+	}
+	if n.EscalateInterval > 0 {
+		policy.Interval = time.Duration(n.EscalateInterval) * time.Second
+	}
+	if n.EscalateMax > 0 {
+		policy.MaxAttempts = n.EscalateMax
+	}
+	return policy
+}
+
+// Send pushes n Notification to the users firebase token and sends it over the users websocket connection. The
+// returned Usage counts whether it was pushed or delivered. An error is returned if the notification could not be
+// sent over the websocket.
+func (n Notification) Send(ctx context.Context, cfg *config.Config, user User) (usage Usage, err error) {
+	if len(user.FirebaseToken) > 0 {
+		if err := SendFirebaseMessage(ctx, cfg, n.FirebaseMessage(user.FirebaseToken)); err != nil {
+			Logger(ctx).Errorf("Problem sending firebase message: %s", err.Error())
+		} else {
+			usage.Pushed++
+		}
+	}
+
+	if len(user.ConnectionID) == 0 {
+		return usage, errors.New("user has no ConnectionID")
+	}
+
+	notificationMsgBytes, err := json.Marshal([]Notification{n})
+	if err != nil {
+		return usage, err
+	}
+	if err = SendWsMessage(ctx, cfg, user.ConnectionID, notificationMsgBytes); err == nil {
+		usage.Delivered++
+	}
+	return usage, err
+}
+
+// FirebaseMessage creates the firebase message of n Notification for a firebase token
+func (n *Notification) FirebaseMessage(token string) *messaging.Message {
+	msg := &messaging.Message{
+		Token: token,
+		Notification: &messaging.Notification{
+			Title: n.Title,
+			Body:  n.Message,
+		},
+	}
+	if len(n.Ciphertext) > 0 {
+		// the content can only be read by the device once it receives the notification over its websocket
+		msg.Notification = &messaging.Notification{Title: sealedNotificationTitle}
+		msg.Data = map[string]string{"uuid": n.UUID}
+	}
+	if len(n.DedupeKey) > 0 {
+		// collapse repeated notifications on the device
+		msg.Android = &messaging.AndroidConfig{CollapseKey: n.DedupeKey}
+		msg.APNS = &messaging.APNSConfig{Headers: map[string]string{"apns-collapse-id": n.DedupeKey}}
+	}
+	return msg
+}
+
 func (n *Notification) SizeKB() int {
 	return binary.Size(reflect.ValueOf(n)) / 1024.0
 }
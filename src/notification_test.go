@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/notifi-backend/lambda-src/sealedbox"
+	"strings"
+	"testing"
+	"time"
+)
+
+var dedupeTests = []struct {
+	name   string
+	key    string
+	window int
+	valid  bool
+}{
+	{"no key", "", 0, true},
+	{"key", "disk-full", 0, true},
+	{"long key", strings.Repeat("a", maxDedupeKey+1), 0, false},
+	{"window", "disk-full", 60, true},
+	{"negative window", "disk-full", -1, false},
+	{"long window", "disk-full", int(maxDedupeWindow.Seconds()) + 1, false},
+	{"overflowing window", "disk-full", 9223372037, false},
+}
+
+func TestDedupeValidity(t *testing.T) {
+	for _, tt := range dedupeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := Notification{
+				Credentials:  RandomString(credentialLen),
+				Title:        "title",
+				DedupeKey:    tt.key,
+				DedupeWindow: tt.window,
+			}
+			err := n.Validate(context.Background())
+			if (err == nil) != tt.valid {
+				t.Errorf("got %v, wanted valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestDedupeWindowDuration(t *testing.T) {
+	n := Notification{}
+	if n.DedupeWindowDuration() != defaultDedupeWindow {
+		t.Errorf("got %v, wanted %v", n.DedupeWindowDuration(), defaultDedupeWindow)
+	}
+
+	n.DedupeWindow = 60
+	if n.DedupeWindowDuration() != time.Minute {
+		t.Errorf("got %v, wanted %v", n.DedupeWindowDuration(), time.Minute)
+	}
+}
+
+func TestFirebaseMessageCollapseKey(t *testing.T) {
+	n := Notification{Title: "title", DedupeKey: Hash("disk-full")}
+	msg := n.FirebaseMessage("token")
+	if msg.Android == nil || msg.Android.CollapseKey != n.DedupeKey {
+		t.Errorf("dedupe key should have been used as the collapse key")
+	}
+}
+
+func TestSeal(t *testing.T) {
+	publicKey, privateKey, err := sealedbox.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := Notification{Credentials: RandomString(credentialLen), Title: "title", Message: "message"}
+	if err := n.Seal(base64.StdEncoding.EncodeToString(publicKey)); err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Title) > 0 || len(n.Message) > 0 {
+		t.Errorf("plaintext should have been removed")
+	}
+	if err := n.Validate(context.Background()); err != nil {
+		t.Errorf("sealed notification should have been valid: %v", err)
+	}
+	if msg := n.FirebaseMessage("token"); msg.Notification.Title != sealedNotificationTitle {
+		t.Errorf("sealed notification should not have pushed its content")
+	}
+
+	box, _ := base64.StdEncoding.DecodeString(n.Ciphertext)
+	content, err := sealedbox.Open(privateKey, box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sealed sealedContent
+	if err := json.Unmarshal(content, &sealed); err != nil || sealed.Title != "title" || sealed.Message != "message" {
+		t.Errorf("got %s %v, wanted sealed content", content, err)
+	}
+}
+
+var ciphertextTests = []struct {
+	name       string
+	ciphertext string
+	title      string
+	valid      bool
+}{
+	{"sealed box", base64.StdEncoding.EncodeToString(make([]byte, sealedbox.Overhead+10)), "", true},
+	{"with plaintext", base64.StdEncoding.EncodeToString(make([]byte, sealedbox.Overhead+10)), "title", false},
+	{"not base64", "!", "", false},
+	{"short", base64.StdEncoding.EncodeToString(make([]byte, sealedbox.Overhead-1)), "", false},
+	{"long", base64.StdEncoding.EncodeToString(make([]byte, maxCiphertextBytes+1)), "", false},
+}
+
+func TestCiphertextValidity(t *testing.T) {
+	for _, tt := range ciphertextTests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := Notification{Credentials: RandomString(credentialLen), Title: tt.title, Ciphertext: tt.ciphertext}
+			err := n.Validate(context.Background())
+			if (err == nil) != tt.valid {
+				t.Errorf("got %v, wanted valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// HandlePublicKey returns the public key senders encrypt end-to-end encrypted notifications to for credentials or a
+// token of them. Unknown credentials and tokens have no public key, so they can not be told apart from those without
+// one.
+func (h *Handlers) HandlePublicKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.IsRateLimited(w, r, "ip:"+RemoteIP(r), h.PlanRateLimit(IPPlan)) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		WriteHttpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	credentials := r.Form.Get("credentials")
+	if !IsToken(credentials) && !IsValidCredentials(credentials) {
+		WriteHttpError(w, r, errors.New("Invalid Credentials"), http.StatusForbidden)
+		return
+	}
+
+	var user User
+	var err error
+	if IsToken(credentials) {
+		var hash string
+		if hash, err = h.sentAs(ctx, credentials); err == nil {
+			err = h.DB.Users().Get("credentials", hash).Index("credentials-index").OneWithContext(ctx, &user)
+		}
+	} else {
+		user, err = GetUserByCredentials(ctx, h.DB, credentials)
+	}
+	if err != nil || len(user.PublicKey) == 0 {
+		WriteHttpError(w, r, errors.New("No public key"), http.StatusNotFound)
+		return
+	}
+
+	k, err := json.Marshal(map[string]string{"public_key": user.PublicKey})
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(k)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandlePublicKeyInvalid(t *testing.T) {
+	h := Handlers{DB: &DB{}, RateLimits: NewMemoryRateLimitStore()}
+	for _, tt := range []struct {
+		credentials string
+		code        int
+	}{
+		{"short", http.StatusForbidden},
+		// tokens are accepted but unknown while tokens are not enabled
+		{TokenPrefix + RandomString(tokenLen), http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		form := url.Values{"credentials": {tt.credentials}}
+		r := httptest.NewRequest(http.MethodPost, "/key", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.HandlePublicKey(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d, wanted %d", tt.credentials, w.Code, tt.code)
+		}
+	}
+}
+
+func TestHandlePublicKeyRateLimited(t *testing.T) {
+	h := Handlers{
+		DB:             &DB{},
+		RateLimits:     NewMemoryRateLimitStore(),
+		RateLimitPlans: map[string]RateLimit{IPPlan: {PerMinute: 1, Burst: 1}},
+	}
+	codes := make([]int, 2)
+	for i := range codes {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/key", strings.NewReader("credentials=short"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.HandlePublicKey(w, r)
+		codes[i] = w.Code
+	}
+	if codes[0] != http.StatusForbidden || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("got %v, wanted the second request from the ip to be rate limited", codes)
+	}
+}
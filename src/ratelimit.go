@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/guregu/dynamo"
+	"github.com/notifi-backend/lambda-src/config"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rate limit plans every user is given unless overridden by RATE_LIMIT_PLANS
+const (
+	DefaultPlan = "default"
+	IPPlan      = "ip"
+)
+
+var defaultRateLimitPlans = map[string]RateLimit{
+	DefaultPlan: {PerMinute: 30, Burst: 60},
+	IPPlan:      {PerMinute: 120, Burst: 240},
+}
+
+// maximum number of buckets kept by a MemoryRateLimitStore before full buckets are dropped
+const maxMemoryBuckets = 10000
+
+// RateLimit is a token bucket that holds up to Burst requests and refills at PerMinute requests a minute
+type RateLimit struct {
+	PerMinute float64 `json:"per_minute"`
+	Burst     float64 `json:"burst"`
+}
+
+// RateLimitStore stores the token buckets of rate limited keys
+type RateLimitStore interface {
+	// Take takes a token from the bucket of key. If the bucket is empty it returns how long until a token is available.
+	Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error)
+}
+
+// tokenBucket is the state of a RateLimit token bucket
+type tokenBucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// take refills b with the tokens accumulated since it was last updated and takes one. If there are none it returns
+// how long until one is available.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) time.Duration {
+	if b.Updated.IsZero() {
+		b.Tokens = limit.Burst
+	} else {
+		b.Tokens = math.Min(limit.Burst, b.Tokens+now.Sub(b.Updated).Minutes()*limit.PerMinute)
+	}
+	b.Updated = now
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return 0
+	}
+	return time.Duration((1 - b.Tokens) / limit.PerMinute * float64(time.Minute))
+}
+
+// full returns whether b would be full at now
+func (b *tokenBucket) full(limit RateLimit, now time.Time) bool {
+	return b.Tokens+now.Sub(b.Updated).Minutes()*limit.PerMinute >= limit.Burst
+}
+
+// MemoryRateLimitStore keeps token buckets in memory, so limits are only enforced per instance
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	limits  map[string]RateLimit
+}
+
+// NewMemoryRateLimitStore creates an empty MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets: map[string]*tokenBucket{},
+		limits:  map[string]RateLimit{},
+	}
+}
+
+// Take takes a token from the bucket of key
+func (s *MemoryRateLimitStore) Take(_ context.Context, key string, limit RateLimit) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.buckets) >= maxMemoryBuckets {
+		for k, b := range s.buckets {
+			if b.full(s.limits[k], now) {
+				delete(s.buckets, k)
+				delete(s.limits, k)
+			}
+		}
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		s.buckets[key] = b
+	}
+	s.limits[key] = limit
+	return b.take(limit, now), nil
+}
+
+// rateLimitItem is a token bucket stored in DynamoDB
+type rateLimitItem struct {
+	Key     string    `dynamo:"key,hash"`
+	Tokens  float64   `dynamo:"tokens"`
+	Updated time.Time `dynamo:"updated_dttm"`
+	Expires time.Time `dynamo:"expires,unixtime"`
+}
+
+// DynamoRateLimitStore keeps token buckets in a DynamoDB table using optimistic locking
+type DynamoRateLimitStore struct {
+	db    *dynamo.DB
+	table string
+}
+
+// Take takes a token from the bucket of key
+func (s *DynamoRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	table := s.db.Table(s.table)
+	for attempt := 0; attempt < 3; attempt++ {
+		var item rateLimitItem
+		err := table.Get("key", key).Consistent(true).OneWithContext(ctx, &item)
+		if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+			return 0, err
+		}
+
+		previous := item.Updated
+		b := tokenBucket{Tokens: item.Tokens, Updated: item.Updated}
+		if wait := b.take(limit, time.Now().UTC()); wait > 0 {
+			return wait, nil
+		}
+
+		put := table.Put(rateLimitItem{
+			Key:     key,
+			Tokens:  b.Tokens,
+			Updated: b.Updated,
+			Expires: b.Updated.Add(time.Duration(limit.Burst / limit.PerMinute * float64(time.Minute))),
+		})
+		if previous.IsZero() {
+			put = put.If("attribute_not_exists('key')")
+		} else {
+			put = put.If("'updated_dttm' = ?", previous)
+		}
+
+		err = put.RunWithContext(ctx)
+		if err == nil {
+			return 0, nil
+		} else if !dynamo.IsCondCheckFailed(err) {
+			return 0, err
+		}
+	}
+	// too many concurrent requests for the same key
+	return time.Second, nil
+}
+
+// redisTakeScript atomically refills and takes a token from a bucket stored as a redis hash, returning how many
+// milliseconds until a token is available
+var redisTakeScript = redis.NewScript(`
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local tokens = burst
+if bucket[1] then
+	tokens = math.min(burst, tonumber(bucket[1]) + (now - tonumber(bucket[2])) * rate)
+end
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) / rate)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate))
+return wait
+`)
+
+// RedisRateLimitStore keeps token buckets in redis
+type RedisRateLimitStore struct {
+	client *redis.Client
+}
+
+// Take takes a token from the bucket of key
+func (s *RedisRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	perMillisecond := limit.PerMinute / float64(time.Minute/time.Millisecond)
+	wait, err := redisTakeScript.Run(ctx, s.client, []string{"ratelimit:" + key},
+		strconv.FormatFloat(perMillisecond, 'f', -1, 64),
+		strconv.FormatFloat(limit.Burst, 'f', -1, 64),
+		time.Now().UnixMilli(),
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// NewRateLimitStore creates the RateLimitStore configured by RATE_LIMIT_STORE (memory, dynamo or redis)
+func NewRateLimitStore(cfg *config.Config, db *DB) (RateLimitStore, error) {
+	switch cfg.RateLimitStore {
+	case "", "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "dynamo":
+		return &DynamoRateLimitStore{db: db.DB, table: cfg.RateLimitTable}, nil
+	case "redis":
+		return &RedisRateLimitStore{client: redis.NewClient(&redis.Options{
+			Addr: cfg.RedisHost,
+		})}, nil
+	}
+	return nil, fmt.Errorf("unknown rate limit store '%s'", cfg.RateLimitStore)
+}
+
+// ParseRateLimitPlans returns the rate limits of each plan with any overrides from the RATE_LIMIT_PLANS json
+func ParseRateLimitPlans(overridesJson string) (map[string]RateLimit, error) {
+	plans := map[string]RateLimit{}
+	for plan, limit := range defaultRateLimitPlans {
+		plans[plan] = limit
+	}
+
+	if len(overridesJson) > 0 {
+		var overrides map[string]RateLimit
+		if err := json.Unmarshal([]byte(overridesJson), &overrides); err != nil {
+			return plans, fmt.Errorf("RATE_LIMIT_PLANS: %w", err)
+		}
+		for plan, limit := range overrides {
+			if limit.PerMinute <= 0 || limit.Burst < 1 {
+				return plans, fmt.Errorf("invalid rate limit for plan '%s'", plan)
+			}
+			plans[plan] = limit
+		}
+	}
+	return plans, nil
+}
+
+// PlanRateLimit returns the RateLimit of plan falling back to the default plan
+func (h *Handlers) PlanRateLimit(plan string) RateLimit {
+	if limit, ok := h.RateLimitPlans[plan]; ok {
+		return limit
+	}
+	return h.RateLimitPlans[DefaultPlan]
+}
+
+// IsRateLimited takes a token for key and writes a 429 response with a Retry-After header if there are none left.
+// Requests are let through if the RateLimitStore is unavailable.
+func (h *Handlers) IsRateLimited(w http.ResponseWriter, r *http.Request, key string, limit RateLimit) bool {
+	wait, err := h.RateLimits.Take(r.Context(), key, limit)
+	if err != nil {
+		Logger(r.Context()).Errorf("Problem rate limiting: %s", err.Error())
+		return false
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		WriteHttpError(w, r, errors.New("Too many requests!"), http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
+
+// RemoteIP returns the ip address of the client of r
+func RemoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
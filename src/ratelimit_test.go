@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limit := RateLimit{PerMinute: 60, Burst: 2}
+	now := time.Now()
+
+	var b tokenBucket
+	for i := 0; i < 2; i++ {
+		if wait := b.take(limit, now); wait != 0 {
+			t.Errorf("token %d should have been available, wait %v", i, wait)
+		}
+	}
+
+	if wait := b.take(limit, now); wait != time.Second {
+		t.Errorf("got wait %v, wanted %v", wait, time.Second)
+	}
+
+	if wait := b.take(limit, now.Add(time.Second)); wait != 0 {
+		t.Errorf("token should have refilled, wait %v", wait)
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{PerMinute: 1, Burst: 1}
+
+	if wait, _ := store.Take(context.Background(), "a", limit); wait != 0 {
+		t.Errorf("first request should not have been limited")
+	}
+	if wait, _ := store.Take(context.Background(), "a", limit); wait == 0 {
+		t.Errorf("second request should have been limited")
+	}
+	if wait, _ := store.Take(context.Background(), "b", limit); wait != 0 {
+		t.Errorf("other keys should not have been limited")
+	}
+}
+
+func TestRateLimitPlans(t *testing.T) {
+	plans, err := ParseRateLimitPlans(`{"pro": {"per_minute": 600, "burst": 1000}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := Handlers{RateLimitPlans: plans}
+	if limit := h.PlanRateLimit("pro"); limit.PerMinute != 600 {
+		t.Errorf("got %v, wanted pro plan", limit)
+	}
+	if limit := h.PlanRateLimit("unknown"); limit != defaultRateLimitPlans[DefaultPlan] {
+		t.Errorf("got %v, wanted default plan", limit)
+	}
+
+	if _, err := ParseRateLimitPlans(`{"pro": {"per_minute": 0, "burst": 1000}}`); err == nil {
+		t.Errorf("invalid plan should have errored")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"github.com/guregu/dynamo"
+	"github.com/sirupsen/logrus"
+)
+
+// ReencryptStats structure of the counts of a re-encryption run
+type ReencryptStats struct {
+	Scanned     int `json:"scanned"`
+	Reencrypted int `json:"reencrypted"`
+	Failed      int `json:"failed"`
+}
+
+// Reencrypt migrates every stored notification and escalation to envelope encryption with a data key wrapped by the
+// current master key. Notifications stored before envelope encryption or before their content was bound to them are
+// re-encrypted and the data keys of the rest are rewrapped if needed. End-to-end encrypted notifications are only
+// stripped of data keys stored by older versions. Rows deleted or given a new data key while being migrated are left
+// as they are.
+func Reencrypt(ctx context.Context, db *DB, envelope *Envelope) (ReencryptStats, error) {
+	var stats ReencryptStats
+
+	var n Notification
+	iter := db.Notifications().Scan().Iter()
+	for iter.NextWithContext(ctx, &n) {
+		stats.Scanned++
+		if needsReencrypt(&n, envelope) {
+			previousDataKey := n.DataKey
+			err := reencryptNotification(ctx, &n, envelope)
+			if err == nil {
+				put := db.Notifications().Put(n)
+				if len(previousDataKey) > 0 {
+					put = put.If("'data_key' = ?", previousDataKey)
+				} else {
+					put = put.If("attribute_exists('uuid') AND attribute_not_exists('data_key')")
+				}
+				err = put.RunWithContext(ctx)
+			}
+			stats.count(ctx, n.UUID, err)
+		}
+		n = Notification{}
+	}
+	if err := iter.Err(); err != nil {
+		return stats, err
+	}
+
+	if !db.EscalationEnabled() {
+		return stats, nil
+	}
+
+	var e Escalation
+	iter = db.Escalations().Scan().Iter()
+	for iter.NextWithContext(ctx, &e) {
+		stats.Scanned++
+		if needsReencrypt(&e.Notification, envelope) {
+			previousDataKey := e.Notification.DataKey
+			err := reencryptNotification(ctx, &e.Notification, envelope)
+			if err == nil {
+				update := db.Escalations().
+					Update("uuid", e.UUID).
+					Set("notification", e.Notification)
+				if len(previousDataKey) > 0 {
+					update = update.If("'notification'.'data_key' = ?", previousDataKey)
+				} else {
+					update = update.If("attribute_exists('uuid') AND attribute_not_exists('notification'.'data_key')")
+				}
+				err = update.RunWithContext(ctx)
+			}
+			stats.count(ctx, e.UUID, err)
+		}
+		e = Escalation{}
+	}
+	return stats, iter.Err()
+}
+
+// needsReencrypt returns whether the stored n Notification is not encrypted with a data key wrapped by the current
+// master key of envelope
+func needsReencrypt(n *Notification, envelope *Envelope) bool {
+	if n.IsSealed() {
+		return n.NeedsReencrypt()
+	}
+	return n.NeedsReencrypt() || envelope.NeedsRewrap(n.DataKey)
+}
+
+// reencryptNotification encrypts the stored n Notification with a data key wrapped by the current master key of
+// envelope. Only the data key is rewrapped if the content is already envelope encrypted.
+func reencryptNotification(ctx context.Context, n *Notification, envelope *Envelope) (err error) {
+	if !n.NeedsReencrypt() {
+		n.DataKey, err = envelope.Rewrap(ctx, n.DataKey)
+		return err
+	}
+
+	if err := n.Decrypt(ctx, envelope); err != nil {
+		return err
+	}
+	return n.Encrypt(ctx, envelope)
+}
+
+// count counts the result err of re-encrypting the row uuid
+func (s *ReencryptStats) count(ctx context.Context, uuid string, err error) {
+	if err == nil {
+		s.Reencrypted++
+	} else if !dynamo.IsCondCheckFailed(err) {
+		s.Failed++
+		Logger(ctx).WithFields(logrus.Fields{
+			"uuid": uuid,
+			"err":  err.Error(),
+		}).Error("problem re-encrypting")
+	}
+}
+
+// HandleReencrypt runs Reencrypt to migrate stored notifications to the current master key after ENCRYPTION_KEY is
+// rotated or KEY_PROVIDER is changed
+func (h *Handlers) HandleReencrypt(ctx context.Context) error {
+	ctx = WithLogger(ctx, logrus.Fields{
+		"request_id": newRequestID(ctx),
+		"route":      "reencrypt",
+	})
+
+	stats, err := Reencrypt(ctx, h.DB, h.Envelope)
+	Logger(ctx).WithFields(logrus.Fields{
+		"key_provider":  h.Envelope.provider.Name(),
+		"active_key_id": h.Envelope.Keyring.ActiveID,
+		"scanned":       stats.Scanned,
+		"reencrypted":   stats.Reencrypted,
+		"failed":        stats.Failed,
+	}).Info("re-encrypted notifications")
+	return err
+}
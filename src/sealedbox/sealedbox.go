@@ -0,0 +1,119 @@
+// Package sealedbox encrypts messages to an X25519 public key so only the holder of the private key can read them.
+//
+// A sealed box is the ephemeral X25519 public key of the sender followed by the message encrypted with
+// ChaCha20-Poly1305 under a key derived with HKDF-SHA256 from the shared secret and both public keys. As every box
+// uses a new ephemeral key, the sender is anonymous and the nonce is always zero.
+//
+// The key is derived with HKDF-SHA256 salted with the ephemeral and recipient public keys and the info
+// "notifi sealed box v1". This is not libsodium's crypto_box_seal, which uses XSalsa20-Poly1305 with a nonce derived
+// with BLAKE2b, so boxes can not be opened or sealed by libsodium.
+package sealedbox
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+// KeySize is the size of X25519 public and private keys
+const KeySize = 32
+
+// Overhead is the number of bytes a sealed box adds to a message
+const Overhead = KeySize + chacha20poly1305.Overhead
+
+var hkdfInfo = []byte("notifi sealed box v1")
+
+// ErrOpen is returned when a sealed box can not be opened with a private key
+var ErrOpen = errors.New("unable to open sealed box")
+
+// GenerateKey generates an X25519 key pair
+func GenerateKey() (publicKey, privateKey []byte, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key.PublicKey().Bytes(), key.Bytes(), nil
+}
+
+// ParsePublicKey decodes a base64 X25519 public key
+func ParsePublicKey(publicKeyB64 string) ([]byte, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(publicKeyB64)
+	if err != nil {
+		return nil, fmt.Errorf("public key is not base64: %w", err)
+	}
+	if _, err := ecdh.X25519().NewPublicKey(publicKey); err != nil {
+		return nil, fmt.Errorf("public key must be a %d byte X25519 key", KeySize)
+	}
+	return publicKey, nil
+}
+
+// Seal encrypts message to publicKey
+func Seal(publicKey, message []byte) ([]byte, error) {
+	recipient, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	aead, err := newAEAD(shared, ephemeralPublic, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(ephemeralPublic, make([]byte, aead.NonceSize()), message, nil), nil
+}
+
+// Open decrypts a box sealed to the public key of privateKey
+func Open(privateKey, box []byte) ([]byte, error) {
+	if len(box) < Overhead {
+		return nil, ErrOpen
+	}
+
+	key, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(box[:KeySize])
+	if err != nil {
+		return nil, ErrOpen
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	aead, err := newAEAD(shared, box[:KeySize], key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	message, err := aead.Open(nil, make([]byte, aead.NonceSize()), box[KeySize:], nil)
+	if err != nil {
+		return nil, ErrOpen
+	}
+	return message, nil
+}
+
+// newAEAD derives the ChaCha20-Poly1305 key of a box from the shared secret and the public keys
+func newAEAD(shared, ephemeralPublic, recipientPublic []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeralPublic...), recipientPublic...)
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, hkdfInfo), key); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(key)
+}
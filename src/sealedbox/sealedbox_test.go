@@ -0,0 +1,55 @@
+package sealedbox
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	publicKey, privateKey, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("hello")
+	box, err := Seal(publicKey, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(box) != len(message)+Overhead {
+		t.Errorf("got box of %d bytes, wanted %d", len(box), len(message)+Overhead)
+	}
+
+	opened, err := Open(privateKey, box)
+	if err != nil || !bytes.Equal(opened, message) {
+		t.Errorf("got %s %v, wanted %s", opened, err, message)
+	}
+
+	_, otherPrivateKey, _ := GenerateKey()
+	if _, err := Open(otherPrivateKey, box); err != ErrOpen {
+		t.Errorf("opening with another key should have failed")
+	}
+
+	box[len(box)-1] ^= 1
+	if _, err := Open(privateKey, box); err != ErrOpen {
+		t.Errorf("opening a modified box should have failed")
+	}
+
+	if _, err := Open(privateKey, box[:Overhead-1]); err != ErrOpen {
+		t.Errorf("opening a short box should have failed")
+	}
+}
+
+func TestParsePublicKey(t *testing.T) {
+	publicKey, _, _ := GenerateKey()
+	if _, err := ParsePublicKey(base64.StdEncoding.EncodeToString(publicKey)); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParsePublicKey(base64.StdEncoding.EncodeToString(publicKey[1:])); err == nil {
+		t.Errorf("short key should have errored")
+	}
+	if _, err := ParsePublicKey("!"); err == nil {
+		t.Errorf("invalid base64 should have errored")
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/sirupsen/logrus"
+)
+
+// invalidServerKeyID is the key id requests without an active server key are counted under
+const invalidServerKeyID = "invalid"
+
+// ErrInvalidServerKey is returned when a request is not sent with an active server key
+var ErrInvalidServerKey = errors.New("Invalid server key")
+
+// ServerKeyID returns the id of the active server key key. Every key is compared in constant time.
+func (h *Handlers) ServerKeyID(key string) (string, bool) {
+	if len(key) == 0 {
+		return "", false
+	}
+
+	var id string
+	for keyID, serverKey := range h.ServerKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(serverKey)) == 1 {
+			id = keyID
+		}
+	}
+	return id, len(id) > 0
+}
+
+// VerifyServerKey verifies key is an active server key, counting the request to handler under the id of the key so
+// the keys of old client releases can be retired once they are no longer used
+func (h *Handlers) VerifyServerKey(ctx context.Context, handler, key string) error {
+	id, ok := h.ServerKeyID(key)
+	if !ok {
+		serverKeyRequestsTotal.Inc(handler, invalidServerKeyID)
+		return ErrInvalidServerKey
+	}
+
+	serverKeyRequestsTotal.Inc(handler, id)
+	AddLogFields(ctx, logrus.Fields{"server_key_id": id})
+	return nil
+}
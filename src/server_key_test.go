@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerKeyID(t *testing.T) {
+	h := Handlers{ServerKeys: map[string]string{"default": "key1", "v2": "key2"}}
+	tests := []struct {
+		key string
+		id  string
+		ok  bool
+	}{
+		{"key1", "default", true},
+		{"key2", "v2", true},
+		{"key3", "", false},
+		{"key", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		id, ok := h.ServerKeyID(tt.key)
+		if id != tt.id || ok != tt.ok {
+			t.Errorf("%s: got %s %v, wanted %s %v", tt.key, id, ok, tt.id, tt.ok)
+		}
+	}
+}
+
+func TestVerifyServerKey(t *testing.T) {
+	h := Handlers{ServerKeys: map[string]string{"v2": "key2"}}
+
+	before := testutil.ToFloat64(serverKeyRequestsTotal.vec.WithLabelValues("test", "v2"))
+	if err := h.VerifyServerKey(context.Background(), "test", "key2"); err != nil {
+		t.Fatal(err)
+	}
+	if after := testutil.ToFloat64(serverKeyRequestsTotal.vec.WithLabelValues("test", "v2")); after-before != 1 {
+		t.Errorf("got %v requests with key v2, wanted 1", after-before)
+	}
+
+	before = testutil.ToFloat64(serverKeyRequestsTotal.vec.WithLabelValues("test", invalidServerKeyID))
+	if err := h.VerifyServerKey(context.Background(), "test", "key1"); !errors.Is(err, ErrInvalidServerKey) {
+		t.Errorf("expected invalid server key got %v", err)
+	}
+	if after := testutil.ToFloat64(serverKeyRequestsTotal.vec.WithLabelValues("test", invalidServerKeyID)); after-before != 1 {
+		t.Errorf("got %v requests with an invalid key, wanted 1", after-before)
+	}
+}
+
+func TestHandleCodeInvalidServerKey(t *testing.T) {
+	h := Handlers{ServerKeys: map[string]string{"default": "key1"}}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/code", nil)
+	r.Header.Set("Sec-Key", "key2")
+	h.HandleCode(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got %d, wanted %d", w.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,77 @@
+// Package signature signs and verifies requests to the notifi api with a shared signing secret.
+//
+// The X-Notifi-Signature header of a signed request is t=<unix timestamp>,v1=<hex HMAC-SHA256> where the HMAC is
+// computed over the method, request uri (path and query), timestamp and body each separated by a newline.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header is the header a request signature is sent in
+const Header = "X-Notifi-Signature"
+
+// MaxAge is how far the timestamp of a signature can be from the time it is verified
+const MaxAge = 5 * time.Minute
+
+// errors verifying a signature
+var (
+	ErrMalformed = errors.New("malformed signature")
+	ErrExpired   = errors.New("signature timestamp is too old or in the future")
+	ErrMismatch  = errors.New("signature does not match")
+)
+
+// Sign returns the Header value of a request signed with secret at t
+func Sign(secret []byte, method, requestURI string, body []byte, t time.Time) string {
+	return fmt.Sprintf("t=%d,v1=%s", t.Unix(), hex.EncodeToString(mac(secret, method, requestURI, body, t.Unix())))
+}
+
+// Verify verifies the Header value of a request was signed with secret within MaxAge of now
+func Verify(secret []byte, header, method, requestURI string, body []byte, now time.Time) error {
+	timestamp, signature, err := parse(header)
+	if err != nil {
+		return err
+	}
+
+	if age := now.Sub(time.Unix(timestamp, 0)); age > MaxAge || age < -MaxAge {
+		return ErrExpired
+	}
+
+	if !hmac.Equal(signature, mac(secret, method, requestURI, body, timestamp)) {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func parse(header string) (timestamp int64, signature []byte, err error) {
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			timestamp, err = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signature, err = hex.DecodeString(value)
+		}
+		if err != nil {
+			return 0, nil, ErrMalformed
+		}
+	}
+	if timestamp == 0 || len(signature) != sha256.Size {
+		return 0, nil, ErrMalformed
+	}
+	return timestamp, signature, nil
+}
+
+func mac(secret []byte, method, requestURI string, body []byte, timestamp int64) []byte {
+	m := hmac.New(sha256.New, secret)
+	_, _ = fmt.Fprintf(m, "%s\n%s\n%d\n", strings.ToUpper(method), requestURI, timestamp)
+	_, _ = m.Write(body)
+	return m.Sum(nil)
+}
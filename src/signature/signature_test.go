@@ -0,0 +1,46 @@
+package signature
+
+import (
+	"testing"
+	"time"
+)
+
+var secret = []byte("secret")
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	body := []byte("credentials=foo&title=bar")
+	header := Sign(secret, "POST", "/api", body, now)
+
+	if err := Verify(secret, header, "POST", "/api", body, now); err != nil {
+		t.Errorf("valid signature errored: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		secret []byte
+		header string
+		method string
+		uri    string
+		body   string
+		now    time.Time
+		err    error
+	}{
+		{"other secret", []byte("other"), header, "POST", "/api", string(body), now, ErrMismatch},
+		{"other method", secret, header, "GET", "/api", string(body), now, ErrMismatch},
+		{"other uri", secret, header, "POST", "/api?title=baz", string(body), now, ErrMismatch},
+		{"other body", secret, header, "POST", "/api", "credentials=foo&title=baz", now, ErrMismatch},
+		{"expired", secret, header, "POST", "/api", string(body), now.Add(MaxAge + time.Second), ErrExpired},
+		{"future", secret, header, "POST", "/api", string(body), now.Add(-MaxAge - time.Second), ErrExpired},
+		{"malformed", secret, "foo", "POST", "/api", string(body), now, ErrMalformed},
+		{"short", secret, "t=1,v1=abcd", "POST", "/api", string(body), now, ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.method, tt.uri, []byte(tt.body), tt.now)
+			if err != tt.err {
+				t.Errorf("got %v, wanted %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	b64 "encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/guregu/dynamo"
+	"github.com/notifi-backend/lambda-src/signature"
+	"io"
+	"net/http"
+	"time"
+)
+
+// maxSignedBodyBytes is the largest body of a signed request
+const maxSignedBodyBytes = 1 << 20
+
+// signatureReplayLimit lets each signature be used once for twice the signature.MaxAge it can be accepted within
+var signatureReplayLimit = RateLimit{PerMinute: 1 / (2 * signature.MaxAge).Minutes(), Burst: 1}
+
+// ErrReplayCheck is returned when it can not be checked whether a signature has already been used
+var ErrReplayCheck = errors.New("Unable to check signature, try again later")
+
+// IssueSigningSecret issues a new secret to the user uuid that /api requests can be signed with. The stored secret is
+// wrapped by the master key of envelope. Unless rotate is set the secret is only issued to a user without one, and ""
+// is returned for a user that already has one.
+func IssueSigningSecret(ctx context.Context, db *DB, envelope *Envelope, uuid string, rotate bool) (string, error) {
+	secret, wrapped, err := envelope.NewDataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	update := db.Users().Update("device_uuid", db.Hasher.Lookup(uuid)).Set("signing_key", wrapped)
+	if !rotate {
+		update = update.If("attribute_not_exists('signing_key')")
+	}
+	err = update.RunWithContext(ctx)
+	if dynamo.IsCondCheckFailed(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return b64.StdEncoding.EncodeToString(secret), nil
+}
+
+// RequireSignature sets whether only signed requests are accepted for the user uuid
+func RequireSignature(ctx context.Context, db *DB, uuid string, require bool) error {
+	update := db.Users().Update("device_uuid", db.Hasher.Lookup(uuid))
+	if require {
+		update = update.Set("require_signature", true)
+	} else {
+		update = update.Remove("require_signature")
+	}
+	return update.RunWithContext(ctx)
+}
+
+// ReadSignedBody reads the body of r if it is signed and replaces it so it can be read again
+func ReadSignedBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if len(r.Header.Get(signature.Header)) == 0 {
+		return nil, nil
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSignedBodyBytes))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// VerifySignature verifies the signature of r with the signing secret of user, only allowing it to be used once.
+// Unsigned requests are accepted unless the user requires them to be signed.
+func (h *Handlers) VerifySignature(r *http.Request, body []byte, user User) error {
+	header := r.Header.Get(signature.Header)
+	if len(header) == 0 {
+		if user.RequireSignature {
+			return errors.New("Requests must be signed!")
+		}
+		return nil
+	}
+
+	if len(user.SigningKey) == 0 {
+		return errors.New("No signing secret has been issued!")
+	}
+	secret, err := h.Envelope.DataKey(r.Context(), user.SigningKey)
+	if err != nil {
+		return err
+	}
+
+	if err := signature.Verify(secret, header, r.Method, r.URL.RequestURI(), body, time.Now()); err != nil {
+		return fmt.Errorf("Invalid signature: %w", err)
+	}
+
+	wait, err := h.RateLimits.Take(r.Context(), "signature:"+Hash(header), signatureReplayLimit)
+	if err != nil {
+		// a replayed request must not be accepted because the store is unavailable
+		Logger(r.Context()).Errorf("Problem checking signature replay: %s", err.Error())
+		return ErrReplayCheck
+	} else if wait > 0 {
+		return errors.New("Signature has already been used!")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/notifi-backend/lambda-src/signature"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	envelope := testEnvelope(t, "2")
+	h := Handlers{Envelope: envelope, RateLimits: NewMemoryRateLimitStore()}
+
+	secret, wrapped, err := envelope.NewDataKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := User{SigningKey: wrapped}
+
+	body := []byte("credentials=foo&title=bar")
+	r := httptest.NewRequest("POST", "/api", strings.NewReader(string(body)))
+	if err := h.VerifySignature(r, nil, user); err != nil {
+		t.Errorf("unsigned request should have been accepted: %v", err)
+	}
+
+	user.RequireSignature = true
+	if err := h.VerifySignature(r, nil, user); err == nil {
+		t.Errorf("unsigned request should have been rejected")
+	}
+
+	r.Header.Set(signature.Header, signature.Sign(secret, "POST", "/api", body, time.Now()))
+	if err := h.VerifySignature(r, body, user); err != nil {
+		t.Errorf("signed request should have been accepted: %v", err)
+	}
+	if err := h.VerifySignature(r, body, user); err == nil {
+		t.Errorf("replayed request should have been rejected")
+	}
+}
+
+// failingRateLimitStore is a RateLimitStore that is unavailable
+type failingRateLimitStore struct{}
+
+func (s failingRateLimitStore) Take(context.Context, string, RateLimit) (time.Duration, error) {
+	return 0, errors.New("unavailable")
+}
+
+func TestVerifySignatureReplayCheckFails(t *testing.T) {
+	envelope := testEnvelope(t, "2")
+	h := Handlers{Envelope: envelope, RateLimits: failingRateLimitStore{}}
+
+	secret, wrapped, err := envelope.NewDataKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := []byte("credentials=foo&title=bar")
+	r := httptest.NewRequest("POST", "/api", strings.NewReader(string(body)))
+	r.Header.Set(signature.Header, signature.Sign(secret, "POST", "/api", body, time.Now()))
+	if err := h.VerifySignature(r, body, User{SigningKey: wrapped}); !errors.Is(err, ErrReplayCheck) {
+		t.Errorf("got %v, wanted signature rejected when replays can not be checked", err)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// HandleStats returns the usage stats of credentials. Unknown credentials get the same response as invalid ones.
+func (h *Handlers) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if h.IsRateLimited(w, r, "ip:"+RemoteIP(r), h.PlanRateLimit(IPPlan)) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		WriteHttpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	credentials := r.Form.Get("credentials")
+	if !IsValidCredentials(credentials) {
+		WriteHttpError(w, r, errors.New("Invalid Credentials"), http.StatusForbidden)
+		return
+	}
+
+	user, err := GetUserByCredentials(r.Context(), h.DB, credentials)
+	if err != nil {
+		WriteHttpError(w, r, errors.New("Invalid Credentials"), http.StatusForbidden)
+		return
+	}
+
+	stats, err := GetUsageStats(r.Context(), h.DB, user.Credentials)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	s, err := json.Marshal(stats)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(s)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandleStatsInvalidCredentials(t *testing.T) {
+	h := Handlers{RateLimits: NewMemoryRateLimitStore()}
+	w := httptest.NewRecorder()
+	form := url.Values{"credentials": {"short"}}
+	r := httptest.NewRequest(http.MethodPost, "/stats", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.HandleStats(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("got %d, wanted %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestHandleStatsRateLimited(t *testing.T) {
+	h := Handlers{
+		RateLimits:     NewMemoryRateLimitStore(),
+		RateLimitPlans: map[string]RateLimit{IPPlan: {PerMinute: 1, Burst: 1}},
+	}
+	codes := make([]int, 2)
+	for i := range codes {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/stats", strings.NewReader("credentials=short"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.HandleStats(w, r)
+		codes[i] = w.Code
+	}
+	if codes[0] != http.StatusForbidden || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("got %v, wanted the second request from the ip to be rate limited", codes)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	"net/http"
+)
+
+// statuses of a sent notification
+const (
+	// SendDelivered is a notification sent over the websocket of the device or read from its queue
+	SendDelivered = "delivered"
+
+	// SendQueued is a notification waiting in the queue for the device to read it
+	SendQueued = "queued"
+)
+
+// SendResult structure of the status of a sent notification
+type SendResult struct {
+	UUID   string `json:"UUID"`
+	Status string `json:"status"`
+}
+
+// UnknownSendResult returns the result of a notification to unknown credentials, which looks the same as one queued
+// for a disconnected device so credentials can not be guessed
+func UnknownSendResult() SendResult {
+	return SendResult{UUID: uuid.New().String(), Status: SendQueued}
+}
+
+// WriteSendResult writes result as json
+func WriteSendResult(w http.ResponseWriter, r *http.Request, result SendResult) {
+	b, err := json.Marshal(result)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// NotificationStatus returns whether the notification uuid sent to the hashed credentials is still queued
+func NotificationStatus(ctx context.Context, db *DB, credentials, uuid string) (string, error) {
+	var n Notification
+	err := db.Notifications().Get("uuid", uuid).OneWithContext(ctx, &n)
+	if errors.Is(err, dynamo.ErrNotFound) || err == nil && n.Credentials != credentials {
+		return SendDelivered, nil
+	} else if err != nil {
+		return "", err
+	}
+	return SendQueued, nil
+}
+
+// HandleStatus returns the SendResult of the notification UUID sent with the credentials or token it was sent with.
+// Notifications of unknown credentials are always queued.
+func (h *Handlers) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.IsRateLimited(w, r, "ip:"+RemoteIP(r), h.PlanRateLimit(IPPlan)) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		WriteHttpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	credentials, id := r.Form.Get("credentials"), r.Form.Get("UUID")
+	if !IsValidUUID(id) {
+		WriteHttpError(w, r, errors.New("Invalid UUID"), http.StatusBadRequest)
+		return
+	}
+	if !IsValidCredentials(credentials) && !IsToken(credentials) {
+		WriteHttpError(w, r, errors.New("Invalid Credentials"), http.StatusForbidden)
+		return
+	}
+
+	result := SendResult{UUID: id, Status: SendQueued}
+	hash, err := h.sentAs(ctx, credentials)
+	if err != nil {
+		WriteSendResult(w, r, result)
+		return
+	}
+
+	result.Status, err = NotificationStatus(ctx, h.DB, hash, id)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	WriteSendResult(w, r, result)
+}
+
+// sentAs returns the hashed credentials notifications sent with the plain credentials or token are stored with
+func (h *Handlers) sentAs(ctx context.Context, credentials string) (string, error) {
+	if IsToken(credentials) {
+		if !h.DB.TokensEnabled() {
+			return "", ErrTokenNotFound
+		}
+		token, err := LookupToken(ctx, h.DB, credentials)
+		return token.Credentials, err
+	}
+	user, err := GetUserByCredentials(ctx, h.DB, credentials)
+	return user.Credentials, err
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandleStatusInvalid(t *testing.T) {
+	h := Handlers{RateLimits: NewMemoryRateLimitStore()}
+	for _, tt := range []struct {
+		form url.Values
+		code int
+	}{
+		{url.Values{"UUID": {"foo"}, "credentials": {RandomString(credentialLen)}}, http.StatusBadRequest},
+		{url.Values{"UUID": {"ad5fb4d7-07a8-4b1a-8a4f-10c4b4ba1a5c"}, "credentials": {"short"}}, http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/status", strings.NewReader(tt.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		h.HandleStatus(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%v: got %d, wanted %d", tt.form, w.Code, tt.code)
+		}
+	}
+}
+
+func TestUnknownSendResult(t *testing.T) {
+	a, b := UnknownSendResult(), UnknownSendResult()
+	if a.Status != SendQueued || !IsValidUUID(a.UUID) || a.UUID == b.UUID {
+		t.Errorf("got %+v and %+v, wanted distinct queued results", a, b)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/guregu/dynamo"
+	"strings"
+	"time"
+)
+
+// TokenPrefix prefixes the value of every Token so it can be sent to /api in place of credentials
+const TokenPrefix = "nt_"
+
+// token restrictions
+const (
+	tokenLen       = 40
+	maxTokens      = 50
+	maxTokenLabel  = 100
+	maxTokenTopics = 20
+	maxTopic       = 64
+)
+
+// ErrTokenNotFound is returned when a Token does not exist or belongs to other credentials
+var ErrTokenNotFound = errors.New("Token not found!")
+
+// ErrTooManyTokens is returned when creating a Token of credentials that already have maxTokens
+var ErrTooManyTokens = fmt.Errorf("You can not have more than %d tokens!", maxTokens)
+
+// Token is a send-only API token derived from credentials. It can be restricted to topics and expire.
+type Token struct {
+	Hash        string    `json:"-" dynamo:"token,hash"`
+	ID          string    `json:"id" dynamo:"id"`
+	Credentials string    `json:"-" dynamo:"credentials"`
+	Label       string    `json:"label" dynamo:"label,allowempty"`
+	Topics      []string  `json:"topics,omitempty" dynamo:"topics,set,omitempty"`
+	Created     time.Time `json:"created" dynamo:"created_dttm"`
+	Expires     int64     `json:"expires,omitempty" dynamo:"expires,omitempty"`
+
+	// only returned when the token is created
+	Value string `json:"token,omitempty" dynamo:"-"`
+}
+
+// TokenRequest structure of a request to create a Token
+type TokenRequest struct {
+	Label     string   `json:"label" schema:"label"`
+	Topics    []string `json:"topics" schema:"topics"`
+	ExpiresIn int      `json:"expires_in" schema:"expires_in"`
+}
+
+// Validate runs validation on r TokenRequest
+func (r TokenRequest) Validate() error {
+	if len(r.Label) > maxTokenLabel {
+		return fmt.Errorf("Label must be at most %d characters!", maxTokenLabel)
+	}
+	if len(r.Topics) > maxTokenTopics {
+		return fmt.Errorf("A token can have at most %d topics!", maxTokenTopics)
+	}
+	for _, topic := range r.Topics {
+		if !IsValidTopic(topic) || len(topic) == 0 {
+			return fmt.Errorf("Invalid topic '%s'!", topic)
+		}
+	}
+	if r.ExpiresIn < 0 {
+		return errors.New("Expiry must be in the future!")
+	}
+	return nil
+}
+
+// IsToken returns whether str is the value of a Token rather than credentials
+func IsToken(str string) bool {
+	return strings.HasPrefix(str, TokenPrefix)
+}
+
+// Allows returns whether t Token can send notifications with topic
+func (t Token) Allows(topic string) bool {
+	if len(t.Topics) == 0 {
+		return true
+	}
+	for _, allowed := range t.Topics {
+		if allowed == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns whether t Token had expired at now
+func (t Token) Expired(now time.Time) bool {
+	return t.Expires > 0 && now.Unix() >= t.Expires
+}
+
+// CreateToken creates a Token of the hashed credentials as requested by r
+func CreateToken(ctx context.Context, db *DB, credentials string, r TokenRequest) (Token, error) {
+	if err := r.Validate(); err != nil {
+		return Token{}, err
+	}
+
+	tokens, err := ListTokens(ctx, db, credentials)
+	if err != nil {
+		return Token{}, err
+	}
+	if len(tokens) >= maxTokens {
+		return Token{}, ErrTooManyTokens
+	}
+
+	value := TokenPrefix + RandomString(tokenLen)
+	t := Token{
+		Hash:        db.Hasher.Lookup(value),
+		ID:          uuid.New().String(),
+		Credentials: credentials,
+		Label:       r.Label,
+		Topics:      r.Topics,
+		Created:     time.Now().UTC(),
+	}
+	if r.ExpiresIn > 0 {
+		t.Expires = t.Created.Add(time.Duration(r.ExpiresIn) * time.Second).Unix()
+	}
+
+	if err := db.Tokens().Put(t).If("attribute_not_exists('token')").RunWithContext(ctx); err != nil {
+		return Token{}, err
+	}
+	t.Value = value
+	return t, nil
+}
+
+// ListTokens returns the unexpired tokens of the hashed credentials
+func ListTokens(ctx context.Context, db *DB, credentials string) ([]Token, error) {
+	var tokens []Token
+	err := db.Tokens().Get("credentials", credentials).Index("credentials-index").AllWithContext(ctx, &tokens)
+	if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+		return nil, err
+	}
+
+	unexpired := []Token{}
+	for _, t := range tokens {
+		if !t.Expired(time.Now()) {
+			unexpired = append(unexpired, t)
+		}
+	}
+	return unexpired, nil
+}
+
+// RevokeToken deletes the token id of the hashed credentials
+func RevokeToken(ctx context.Context, db *DB, credentials, id string) error {
+	tokens, err := ListTokens(ctx, db, credentials)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tokens {
+		if t.ID == id {
+			return db.Tokens().Delete("token", t.Hash).If("'credentials' = ?", credentials).RunWithContext(ctx)
+		}
+	}
+	return ErrTokenNotFound
+}
+
+// LookupToken returns the unexpired Token with value, which may have been stored before LOOKUP_KEY was set
+func LookupToken(ctx context.Context, db *DB, value string) (Token, error) {
+	for _, hash := range db.Hasher.LookupCandidates(value) {
+		var t Token
+		err := db.Tokens().Get("token", hash).OneWithContext(ctx, &t)
+		if errors.Is(err, dynamo.ErrNotFound) {
+			continue
+		} else if err != nil {
+			return Token{}, err
+		}
+
+		if t.Expired(time.Now()) {
+			return Token{}, ErrTokenNotFound
+		}
+		return t, nil
+	}
+	return Token{}, ErrTokenNotFound
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/iris-contrib/schema"
+	"net/http"
+)
+
+// HandleTokens lists (GET), creates (POST) or revokes (DELETE) the send-only tokens of the credentials
+func (h *Handlers) HandleTokens(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.IsRateLimited(w, r, "ip:"+RemoteIP(r), h.PlanRateLimit(IPPlan)) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		WriteHttpError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	if !h.DB.TokensEnabled() {
+		WriteHttpError(w, r, errors.New("Tokens are not enabled!"), http.StatusNotFound)
+		return
+	}
+
+	credentials := r.Form.Get("credentials")
+	if !IsValidCredentials(credentials) {
+		WriteHttpError(w, r, errors.New("Invalid Credentials"), http.StatusForbidden)
+		return
+	}
+
+	user, err := GetUserByCredentials(ctx, h.DB, credentials)
+	if err != nil {
+		WriteHttpError(w, r, errors.New("Invalid Credentials"), http.StatusForbidden)
+		return
+	}
+
+	var resp interface{}
+	switch r.Method {
+	case http.MethodGet:
+		tokens, err := ListTokens(ctx, h.DB, user.Credentials)
+		if err != nil {
+			WriteHttpError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		resp = map[string][]Token{"tokens": tokens}
+	case http.MethodPost:
+		var req TokenRequest
+		decoder := schema.NewDecoder()
+		decoder.IgnoreUnknownKeys(true)
+		if err := decoder.Decode(&req, r.Form); err != nil {
+			WriteHttpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		if err := req.Validate(); err != nil {
+			WriteHttpError(w, r, err, http.StatusBadRequest)
+			return
+		}
+		token, err := CreateToken(ctx, h.DB, user.Credentials, req)
+		if errors.Is(err, ErrTooManyTokens) {
+			WriteHttpError(w, r, err, http.StatusConflict)
+			return
+		} else if err != nil {
+			WriteHttpError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		resp = map[string]Token{"token": token}
+	case http.MethodDelete:
+		err := RevokeToken(ctx, h.DB, user.Credentials, r.Form.Get("id"))
+		if errors.Is(err, ErrTokenNotFound) {
+			WriteHttpError(w, r, err, http.StatusNotFound)
+			return
+		} else if err != nil {
+			WriteHttpError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	s, err := json.Marshal(resp)
+	if err != nil {
+		WriteHttpError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(s)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenAllows(t *testing.T) {
+	tests := []struct {
+		topics []string
+		topic  string
+		allows bool
+	}{
+		{nil, "", true},
+		{nil, "deploys", true},
+		{[]string{"deploys"}, "deploys", true},
+		{[]string{"deploys", "alerts"}, "alerts", true},
+		{[]string{"deploys"}, "alerts", false},
+		{[]string{"deploys"}, "", false},
+	}
+	for i, tt := range tests {
+		if got := (Token{Topics: tt.topics}).Allows(tt.topic); got != tt.allows {
+			t.Errorf("%d: expected %v got %v", i, tt.allows, got)
+		}
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	now := time.Now()
+	if (Token{}).Expired(now) {
+		t.Errorf("token without expiry should not expire")
+	}
+	if (Token{Expires: now.Add(time.Minute).Unix()}).Expired(now) {
+		t.Errorf("token should not have expired yet")
+	}
+	if !(Token{Expires: now.Unix()}).Expired(now) {
+		t.Errorf("token should have expired")
+	}
+}
+
+func TestIsToken(t *testing.T) {
+	if !IsToken(TokenPrefix + RandomString(tokenLen)) {
+		t.Errorf("expected token")
+	}
+	if IsToken(RandomString(credentialLen)) {
+		t.Errorf("credentials should not be a token")
+	}
+}
+
+func TestTokenRequestValidate(t *testing.T) {
+	tests := []struct {
+		r     TokenRequest
+		valid bool
+	}{
+		{TokenRequest{}, true},
+		{TokenRequest{Label: "ci", Topics: []string{"deploys", "build.status"}, ExpiresIn: 3600}, true},
+		{TokenRequest{Label: strings.Repeat("a", maxTokenLabel+1)}, false},
+		{TokenRequest{Topics: []string{""}}, false},
+		{TokenRequest{Topics: []string{"has space"}}, false},
+		{TokenRequest{Topics: []string{strings.Repeat("a", maxTopic+1)}}, false},
+		{TokenRequest{Topics: make([]string, maxTokenTopics+1)}, false},
+		{TokenRequest{ExpiresIn: -1}, false},
+	}
+	for i, tt := range tests {
+		if err := tt.r.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%d: expected valid %v got %v", i, tt.valid, err)
+		}
+	}
+}
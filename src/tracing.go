@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"os"
+)
+
+// TracerName is the instrumentation name of all spans
+const TracerName = "github.com/notifi-backend/lambda-src"
+
+var tracer = otel.Tracer(TracerName)
+
+// tracerProvider is set when traces are exported
+var tracerProvider *sdktrace.TracerProvider
+
+// InitTracing exports traces over OTLP when OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set.
+// The exporter is configured with the standard OTEL_EXPORTER_OTLP_* env and OTEL_EXPORTER_OTLP_PROTOCOL chooses
+// between grpc and http/protobuf (default).
+func InitTracing(ctx context.Context) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if len(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")) == 0 && len(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")) == 0 {
+		return nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	if os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL") == "grpc" {
+		exporter, err = otlptracegrpc.New(ctx)
+	} else {
+		exporter, err = otlptracehttp.New(ctx)
+	}
+	if err != nil {
+		return err
+	}
+
+	SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter)))
+	return nil
+}
+
+// SetTracerProvider sets the provider spans are recorded with
+func SetTracerProvider(tp *sdktrace.TracerProvider) {
+	tracerProvider = tp
+	otel.SetTracerProvider(tp)
+}
+
+// FlushTraces exports all recorded spans, which must be done before a lambda invocation returns
+func FlushTraces(ctx context.Context) {
+	if tracerProvider != nil {
+		_ = tracerProvider.ForceFlush(ctx)
+	}
+}
+
+// EndSpan records err on span before ending it
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TracingMiddleware starts a span for each request, continuing any trace from the traceparent header
+func TracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.URL.Path, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && len(rctx.RoutePattern()) > 0 {
+			span.SetName(rctx.RoutePattern())
+		}
+		span.SetAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.Int("http.response.status_code", ww.Status()),
+		)
+		if ww.Status() >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(ww.Status()))
+		}
+	})
+}
+
+// TraceWebsocket starts a span for each request to the websocket handler named name, continuing any trace from the
+// traceparent header
+func TraceWebsocket(name string, handler WebsocketHandler) WebsocketHandler {
+	return func(ctx context.Context, r events.APIGatewayWebsocketProxyRequest) (events.APIGatewayProxyResponse, error) {
+		ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(r.Headers))
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer))
+		resp, err := handler(ctx, r)
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		EndSpan(span, err)
+		FlushTraces(ctx)
+		return resp, err
+	}
+}
+
+// TraceScheduled starts a span for each run of a scheduled handler, flushing it before the lambda is frozen
+func TraceScheduled(name string, handler ScheduledHandler) ScheduledHandler {
+	return func(ctx context.Context, e events.CloudWatchEvent) error {
+		ctx, span := tracer.Start(ctx, name)
+		err := handler(ctx, e)
+		EndSpan(span, err)
+		FlushTraces(ctx)
+		return err
+	}
+}
+
+type dynamoSpanKey struct{}
+
+// traceDynamoRequests adds a client span to every DynamoDB request sent with handlers
+func traceDynamoRequests(handlers *request.Handlers) {
+	handlers.Build.PushFront(func(r *request.Request) {
+		ctx, span := tracer.Start(r.Context(), "dynamodb."+r.Operation.Name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", "dynamodb"),
+				attribute.String("db.operation", r.Operation.Name),
+			),
+		)
+		r.SetContext(context.WithValue(ctx, dynamoSpanKey{}, span))
+	})
+	handlers.Complete.PushBack(func(r *request.Request) {
+		if span, ok := r.Context().Value(dynamoSpanKey{}).(trace.Span); ok {
+			EndSpan(span, r.Error)
+		}
+	})
+}
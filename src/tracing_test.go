@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	provider, global, propagator := tracerProvider, otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	t.Cleanup(func() {
+		tracerProvider = provider
+		otel.SetTracerProvider(global)
+		otel.SetTextMapPropagator(propagator)
+	})
+
+	recorder := tracetest.NewSpanRecorder()
+	SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	image := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "10")
+	}))
+	defer image.Close()
+
+	r := chi.NewRouter()
+	r.Use(TracingMiddleware)
+	r.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = imageContentLength(r.Context(), image.URL)
+	})
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/image", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, wanted 2", len(spans))
+	}
+	for _, span := range spans {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("span %s should have continued the trace from the traceparent header", span.Name())
+		}
+	}
+	if spans[1].Name() != "/image" {
+		t.Errorf("got span name %s, wanted route pattern", spans[1].Name())
+	}
+}
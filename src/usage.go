@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/guregu/dynamo"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// periods usage is counted over and how long each is kept
+const (
+	hourlyUsagePrefix = "hour#"
+	hourlyUsageLayout = "2006-01-02T15"
+	hourlyUsageKept   = 48 * time.Hour
+	dailyUsagePrefix  = "day#"
+	dailyUsageLayout  = "2006-01-02"
+	dailyUsageKept    = 90 * 24 * time.Hour
+)
+
+// Usage structure of notification counts of a credential over a period
+type Usage struct {
+	Credentials string `json:"-" dynamo:"credentials,hash"`
+	Period      string `json:"period" dynamo:"period,range"`
+	Sent        int    `json:"sent" dynamo:"sent"`
+	Delivered   int    `json:"delivered" dynamo:"delivered"`
+	Queued      int    `json:"queued" dynamo:"queued"`
+	Pushed      int    `json:"pushed" dynamo:"pushed"`
+	Rejected    int    `json:"rejected" dynamo:"rejected"`
+	Expires     int64  `json:"-" dynamo:"expires,omitempty"`
+}
+
+// UsageStats structure of the recent hourly and daily Usage of a credential
+type UsageStats struct {
+	Hourly []Usage `json:"hourly"`
+	Daily  []Usage `json:"daily"`
+}
+
+// Record adds the counts of u Usage to the current hourly and daily usage of credentials
+func (u Usage) Record(ctx context.Context, db *DB, credentials string) {
+	if !db.UsageEnabled() || u == (Usage{}) {
+		return
+	}
+
+	for period, expires := range usagePeriods(time.Now()) {
+		if err := u.add(ctx, db, credentials, period, expires); err != nil {
+			Logger(ctx).WithFields(logrus.Fields{
+				"period": period,
+				"err":    err.Error(),
+			}).Error("problem recording usage")
+		}
+	}
+}
+
+// add adds the counts of u Usage to the usage of credentials over period, expiring it at expires
+func (u Usage) add(ctx context.Context, db *DB, credentials, period string, expires int64) error {
+	update := db.Usage().
+		Update("credentials", credentials).
+		Range("period", period).
+		Set("expires", expires)
+	for name, cnt := range u.counts() {
+		update = update.Add(name, cnt)
+	}
+	return update.RunWithContext(ctx)
+}
+
+// usagePeriods returns the hourly and daily periods usage at now is counted in and when each expires
+func usagePeriods(now time.Time) map[string]int64 {
+	now = now.UTC()
+	return map[string]int64{
+		hourlyUsagePrefix + now.Format(hourlyUsageLayout): now.Add(hourlyUsageKept).Unix(),
+		dailyUsagePrefix + now.Format(dailyUsageLayout):   now.Add(dailyUsageKept).Unix(),
+	}
+}
+
+// counts returns the counts of u Usage that are not zero by their attribute
+func (u Usage) counts() map[string]int {
+	counts := map[string]int{}
+	for name, cnt := range map[string]int{
+		"sent":      u.Sent,
+		"delivered": u.Delivered,
+		"queued":    u.Queued,
+		"pushed":    u.Pushed,
+		"rejected":  u.Rejected,
+	} {
+		if cnt > 0 {
+			counts[name] = cnt
+		}
+	}
+	return counts
+}
+
+// usageStatsPeriods returns the first and last hourly and daily periods of the UsageStats at now
+func usageStatsPeriods(now time.Time) (hourly, daily [2]string) {
+	now = now.UTC()
+	hourly = [2]string{
+		hourlyUsagePrefix + now.Add(-24*time.Hour).Format(hourlyUsageLayout),
+		hourlyUsagePrefix + now.Format(hourlyUsageLayout),
+	}
+	daily = [2]string{
+		dailyUsagePrefix + now.AddDate(0, 0, -30).Format(dailyUsageLayout),
+		dailyUsagePrefix + now.Format(dailyUsageLayout),
+	}
+	return hourly, daily
+}
+
+// MigrateUsage moves the usage of the hashed credentials from to the hashed credentials to, adding it to any usage
+// already recorded under to
+func MigrateUsage(ctx context.Context, db *DB, from, to string) (int, error) {
+	if !db.UsageEnabled() {
+		return 0, nil
+	}
+
+	var usage []Usage
+	err := db.Usage().Get("credentials", from).AllWithContext(ctx, &usage)
+	if err != nil && !errors.Is(err, dynamo.ErrNotFound) {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, u := range usage {
+		if err := u.add(ctx, db, to, u.Period, u.Expires); err != nil {
+			return migrated, err
+		}
+		if err := db.Usage().Delete("credentials", from).Range("period", u.Period).RunWithContext(ctx); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// RecordRejected records a rejected notification against the plain credentials if they belong to a user
+func RecordRejected(ctx context.Context, db *DB, credentials string) {
+	if !db.UsageEnabled() || !IsValidCredentials(credentials) {
+		return
+	}
+
+	user, err := GetUserByCredentials(ctx, db, credentials)
+	if err == nil {
+		Usage{Rejected: 1}.Record(ctx, db, user.Credentials)
+	}
+}
+
+// GetUsageStats returns the hourly usage of the last day and the daily usage of the last month of credentials
+func GetUsageStats(ctx context.Context, db *DB, credentials string) (UsageStats, error) {
+	stats := UsageStats{Hourly: []Usage{}, Daily: []Usage{}}
+	if !db.UsageEnabled() {
+		return stats, errors.New("usage stats are not enabled")
+	}
+
+	hourly, daily := usageStatsPeriods(time.Now())
+	err := db.Usage().
+		Get("credentials", credentials).
+		Range("period", dynamo.Between, hourly[0], hourly[1]).
+		AllWithContext(ctx, &stats.Hourly)
+	if err != nil {
+		return stats, err
+	}
+
+	err = db.Usage().
+		Get("credentials", credentials).
+		Range("period", dynamo.Between, daily[0], daily[1]).
+		AllWithContext(ctx, &stats.Daily)
+	return stats, err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUsagePeriods(t *testing.T) {
+	now := time.Date(2024, 3, 1, 23, 59, 0, 0, time.FixedZone("CET", 60*60))
+	periods := usagePeriods(now)
+	if len(periods) != 2 {
+		t.Fatalf("got %v, wanted an hourly and daily period", periods)
+	}
+	if expires, ok := periods["hour#2024-03-01T22"]; !ok || expires != now.Add(hourlyUsageKept).Unix() {
+		t.Errorf("got %v, wanted the utc hour kept for %s", periods, hourlyUsageKept)
+	}
+	if expires, ok := periods["day#2024-03-01"]; !ok || expires != now.Add(dailyUsageKept).Unix() {
+		t.Errorf("got %v, wanted the utc day kept for %s", periods, dailyUsageKept)
+	}
+}
+
+func TestUsageStatsPeriods(t *testing.T) {
+	hourly, daily := usageStatsPeriods(time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC))
+	if hourly != [2]string{"hour#2024-02-29T12", "hour#2024-03-01T12"} {
+		t.Errorf("got %v, wanted the last day of hours", hourly)
+	}
+	if daily != [2]string{"day#2024-01-31", "day#2024-03-01"} {
+		t.Errorf("got %v, wanted the last 30 days", daily)
+	}
+
+	// periods sort in time order so they can be queried as a range
+	if !(hourly[0] < hourly[1] && daily[0] < daily[1]) {
+		t.Errorf("got %v %v, wanted periods in time order", hourly, daily)
+	}
+}
+
+func TestUsageCounts(t *testing.T) {
+	counts := Usage{Sent: 2, Queued: 1}.counts()
+	if len(counts) != 2 || counts["sent"] != 2 || counts["queued"] != 1 {
+		t.Errorf("got %v, wanted only the sent and queued counts", counts)
+	}
+	if counts := (Usage{}).counts(); len(counts) != 0 {
+		t.Errorf("got %v, wanted no counts", counts)
+	}
+}
+
+func TestUsageStatsJSON(t *testing.T) {
+	stats := UsageStats{
+		Hourly: []Usage{{Credentials: "credentials", Period: "hour#2024-03-01T12", Sent: 3, Delivered: 2, Queued: 1}},
+		Daily:  []Usage{},
+	}
+	b, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "credentials") || !strings.Contains(string(b), `"daily":[]`) {
+		t.Errorf("got %s, wanted credentials hidden and empty periods as arrays", b)
+	}
+
+	var decoded UsageStats
+	if err := json.Unmarshal(b, &decoded); err != nil || decoded.Hourly[0].Sent != 3 || decoded.Hourly[0].Period != stats.Hourly[0].Period {
+		t.Errorf("got %+v %v, wanted stats to round trip", decoded, err)
+	}
+}
@@ -1,64 +1,97 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/guregu/dynamo"
-	"os"
+	"github.com/sirupsen/logrus"
 	"time"
 )
 
-var UserTable = os.Getenv("USER_TABLE_NAME")
-
 // User structure
 type User struct {
-	AppVersion      string    `dynamo:"app_version"`
-	Created         time.Time `dynamo:"created_dttm"`
-	Credentials     string    `dynamo:"credentials,hash"`
-	CredentialsKey  string    `dynamo:"credential_key"`
-	ConnectionID    string    `dynamo:"connection_id,hash"`
-	OS              string    `dynamo:"operating_system"`
-	FirebaseToken   string    `dynamo:"firebase_token,allowempty"`
-	LastLogin       time.Time `dynamo:"last_login_dttm"`
-	NotificationCnt int       `dynamo:"notification_cnt"`
-	UUID            string    `dynamo:"device_uuid,hash"`
+	AppVersion       string    `dynamo:"app_version"`
+	Created          time.Time `dynamo:"created_dttm"`
+	Credentials      string    `dynamo:"credentials,hash"`
+	CredentialsKey   string    `dynamo:"credential_key"`
+	ConnectionID     string    `dynamo:"connection_id,hash"`
+	OS               string    `dynamo:"operating_system"`
+	FirebaseToken    string    `dynamo:"firebase_token,allowempty"`
+	LastLogin        time.Time `dynamo:"last_login_dttm"`
+	NotificationCnt  int       `dynamo:"notification_cnt"`
+	Plan             string    `dynamo:"plan,omitempty"`
+	PublicKey        string    `dynamo:"public_key,omitempty"`
+	SigningKey       string    `dynamo:"signing_key,omitempty"`
+	RequireSignature bool      `dynamo:"require_signature,omitempty"`
+	UUID             string    `dynamo:"device_uuid,hash"`
+
+	// credentials previously and currently issued to the device
+	CredentialHistory []CredentialRecord `dynamo:"credential_history,omitempty"`
 }
 
 // Credentials structure
 type Credentials struct {
 	Value string `json:"credentials"`
 	Key   string `json:"credential_key"`
+
+	// secret /api requests can be signed with
+	SigningSecret string `json:"signing_secret,omitempty"`
+
+	// why the credentials were issued and the hash of the credentials they replace
+	Reason  string `json:"-"`
+	Revoked string `json:"-"`
 }
 
+// ErrInvalidCredentialKey is returned when new Credentials are requested without the current credential key
+var ErrInvalidCredentialKey = errors.New("unable to create new credentials")
+
 const (
 	credentialLen    = 25
 	credentialKeyLen = 100
 )
 
 // Store stores or updates u User with new Credentials depending on whether the user passes current Credentials
-// in the u User struct.
-func (user User) Store(db *dynamo.DB) (Credentials, error) {
+// in the u User struct. Issued credentials are recorded in the CredentialHistory of the user.
+func (user User) Store(ctx context.Context, db *DB) (Credentials, error) {
 	newCredentials := Credentials{
-		RandomString(credentialLen),
-		RandomString(credentialKeyLen),
+		Value: RandomString(credentialLen),
+		Key:   RandomString(credentialKeyLen),
+	}
+
+	StoredUser, _ := GetUserByUUID(ctx, db, user.UUID)
+	if len(user.PublicKey) > 0 {
+		StoredUser.PublicKey = user.PublicKey
+	}
+
+	// users stored before LOOKUP_KEY was set are moved to the keyed uuid
+	var legacyUUID string
+	if uuid := db.Hasher.Lookup(user.UUID); len(StoredUser.UUID) > 0 && StoredUser.UUID != uuid {
+		legacyUUID = StoredUser.UUID
+		StoredUser.UUID = uuid
+	}
+
+	key, err := db.Hasher.Password(newCredentials.Key)
+	if err != nil {
+		return Credentials{}, err
 	}
 
-	var StoredUser User
-	_ = db.Table(UserTable).Get("device_uuid", Hash(user.UUID)).One(&StoredUser)
 	if len(StoredUser.UUID) > 0 {
 		if len(StoredUser.CredentialsKey) == 0 && len(StoredUser.Credentials) > 0 {
-			StoredUser.CredentialsKey = PassHash(newCredentials.Key)
-			if err := db.Table(UserTable).Put(StoredUser).Run(); err != nil {
+			StoredUser.CredentialsKey = key
+			if err := putUser(ctx, db, StoredUser, legacyUUID); err != nil {
 				return Credentials{}, err
 			}
 			newCredentials.Value = ""
+			newCredentials.Reason = IssuedKey
 			return newCredentials, nil
 		} else if len(StoredUser.CredentialsKey) == 0 && len(StoredUser.Credentials) == 0 {
-			StoredUser.CredentialsKey = PassHash(newCredentials.Key)
-			StoredUser.Credentials = Hash(newCredentials.Value)
-			if err := db.Table(UserTable).Put(StoredUser).Run(); err != nil {
+			StoredUser.CredentialsKey = key
+			StoredUser.issueCredentials(db.Hasher.Lookup(newCredentials.Value), time.Now())
+			if err := putUser(ctx, db, StoredUser, legacyUUID); err != nil {
 				return Credentials{}, err
 			}
+			newCredentials.Reason = IssuedNew
 			return newCredentials, nil
 		}
 	}
@@ -69,33 +102,111 @@ func (user User) Store(db *dynamo.DB) (Credentials, error) {
 		if len(user.CredentialsKey) > 0 && IsValidCredentials(user.Credentials) {
 			// If client passes current details they are asking for new Credentials.
 			// Verify the Credentials passed are valid
-			if user.Verify(StoredUser) {
+			if user.Verify(StoredUser, db.Hasher) {
 				isNewUser = false
 			} else {
-				return Credentials{}, errors.New("unable to create new credentials")
+				return Credentials{}, ErrInvalidCredentialKey
 			}
 		}
 	}
 
 	if isNewUser && len(StoredUser.UUID) > 0 {
-		return Credentials{}, fmt.Errorf("UUID (%s) already exists", Hash(user.UUID))
+		return Credentials{}, fmt.Errorf("UUID (%s) already exists", StoredUser.UUID)
 	}
 
-	StoredUser.Credentials = Hash(newCredentials.Value)
-	StoredUser.CredentialsKey = PassHash(newCredentials.Key)
-	StoredUser.UUID = Hash(user.UUID)
+	newCredentials.Reason = IssuedNew
+	if !isNewUser {
+		newCredentials.Reason = IssuedRotated
+		newCredentials.Revoked = StoredUser.Credentials
+	}
+
+	StoredUser.CredentialsKey = key
+	StoredUser.UUID = db.Hasher.Lookup(user.UUID)
 	StoredUser.Created = time.Now()
+	StoredUser.issueCredentials(db.Hasher.Lookup(newCredentials.Value), StoredUser.Created)
 
 	// create or update new user
-	if err := db.Table(UserTable).Put(StoredUser).Run(); err != nil {
+	if err := putUser(ctx, db, StoredUser, legacyUUID); err != nil {
 		return Credentials{}, err
 	}
 	return newCredentials, nil
 }
 
 // Verify verifies a u User s credentials
-func (user User) Verify(dbUser User) bool {
-	isValidKey := VerifyPassHash(dbUser.CredentialsKey, user.CredentialsKey)
-	isValidUUID := dbUser.UUID == Hash(user.UUID)
+func (user User) Verify(dbUser User, hasher *Hasher) bool {
+	isValidKey := hasher.VerifyPassword(dbUser.CredentialsKey, user.CredentialsKey)
+	isValidUUID := hasher.MatchesLookup(dbUser.UUID, user.UUID)
 	return isValidKey && isValidUUID
 }
+
+// GetUserByUUID returns the user of the device uuid, which may have been stored before LOOKUP_KEY was set
+func GetUserByUUID(ctx context.Context, db *DB, uuid string) (user User, err error) {
+	for _, hash := range db.Hasher.LookupCandidates(uuid) {
+		if err = db.Users().Get("device_uuid", hash).OneWithContext(ctx, &user); !errors.Is(err, dynamo.ErrNotFound) {
+			return user, err
+		}
+	}
+	return user, err
+}
+
+// GetUserByCredentials returns the user of the plain credentials, which may have been stored before LOOKUP_KEY was
+// set
+func GetUserByCredentials(ctx context.Context, db *DB, credentials string) (user User, err error) {
+	for _, hash := range db.Hasher.LookupCandidates(credentials) {
+		err = db.Users().Get("credentials", hash).Index("credentials-index").OneWithContext(ctx, &user)
+		if !errors.Is(err, dynamo.ErrNotFound) {
+			return user, err
+		}
+	}
+	return user, err
+}
+
+// putUser stores user and removes the row of legacyUUID it was moved from, if any
+func putUser(ctx context.Context, db *DB, user User, legacyUUID string) error {
+	if err := db.Users().Put(user).RunWithContext(ctx); err != nil {
+		return err
+	}
+	if len(legacyUUID) > 0 {
+		return db.Users().Delete("device_uuid", legacyUUID).RunWithContext(ctx)
+	}
+	return nil
+}
+
+// MigrateLookupHashes moves the stored user, and the queued notifications, tokens and usage of its credentials, from the
+// unkeyed hashes they were stored under before LOOKUP_KEY was set to the keyed hashes of the plain user
+func MigrateLookupHashes(ctx context.Context, db *DB, envelope *Envelope, stored *User, plain User) error {
+	var legacyUUID string
+	if uuid := db.Hasher.Lookup(plain.UUID); stored.UUID != uuid {
+		legacyUUID, stored.UUID = stored.UUID, uuid
+	}
+
+	legacyCredentials := stored.Credentials
+	credentials := db.Hasher.Lookup(plain.Credentials)
+	migrateCredentials := legacyCredentials != credentials && legacyCredentials == Hash(plain.Credentials)
+	if migrateCredentials {
+		stored.rehashCredentials(credentials)
+	}
+
+	if len(legacyUUID) == 0 && !migrateCredentials {
+		return nil
+	}
+	if err := putUser(ctx, db, *stored, legacyUUID); err != nil {
+		return err
+	}
+	if !migrateCredentials {
+		return nil
+	}
+
+	stats, err := MigrateCredentials(ctx, db, envelope, legacyCredentials, credentials, false)
+	usage, usageErr := MigrateUsage(ctx, db, legacyCredentials, credentials)
+	Logger(ctx).WithFields(logrus.Fields{
+		"migrated_notifications": stats.Migrated,
+		"purged_notifications":   stats.Purged,
+		"failed_notifications":   stats.Failed,
+		"migrated_usage":         usage,
+	}).Info("migrated user to keyed lookup hashes")
+	if err != nil {
+		return err
+	}
+	return usageErr
+}
@@ -1,32 +1,37 @@
 package main
 
 import (
+	"context"
 	_ "database/sql"
+	"encoding/base64"
+	"firebase.google.com/go/v4/messaging"
 	"fmt"
+	"github.com/appleboy/go-fcm"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigatewaymanagementapi"
+	"github.com/notifi-backend/lambda-src/config"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 	"net/http"
-	"os"
 	"runtime"
 )
 
-func NewAPIGatewaySession() *apigatewaymanagementapi.ApiGatewayManagementApi {
+func NewAPIGatewaySession(cfg *config.Config) *apigatewaymanagementapi.ApiGatewayManagementApi {
 	sesh := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 		Config: aws.Config{
-			Region:   aws.String(os.Getenv("AWS_REGION")),
-			Endpoint: aws.String(os.Getenv("WS_ENDPOINT")),
+			Region:   aws.String(cfg.AWSRegion),
+			Endpoint: aws.String(cfg.WSEndpoint),
 		},
 	}))
 	return apigatewaymanagementapi.New(sesh)
 }
 
-func WriteError(err error, code int) (events.APIGatewayProxyResponse, error) {
+func WriteError(ctx context.Context, err error, code int) (events.APIGatewayProxyResponse, error) {
 	_, file, no, _ := runtime.Caller(1)
-	logrus.WithFields(
+	Logger(ctx).WithFields(
 		logrus.Fields{
 			"path": fmt.Sprintf("%s#%d", file, no),
 			"code": code,
@@ -44,33 +49,59 @@ func WriteEmptySuccess() (events.APIGatewayProxyResponse, error) {
 	}, nil
 }
 
-func SendWsMessage(connectionID string, msgData []byte) error {
+func SendWsMessage(ctx context.Context, cfg *config.Config, connectionID string, msgData []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "SendWsMessage", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { EndSpan(span, err) }()
+
 	connectionInput := &apigatewaymanagementapi.PostToConnectionInput{
 		ConnectionId: aws.String(connectionID),
 		Data:         msgData,
 	}
 
-	_, err := NewAPIGatewaySession().PostToConnection(connectionInput)
+	_, err = NewAPIGatewaySession(cfg).PostToConnectionWithContext(ctx, connectionInput)
+	if err != nil {
+		wsSendFailuresTotal.Inc()
+	}
 	return err
 }
 
-func CloseConnection(connectionID string) error {
+func SendFirebaseMessage(ctx context.Context, cfg *config.Config, msg *messaging.Message) (err error) {
+	ctx, span := tracer.Start(ctx, "SendFirebaseMessage", trace.WithSpanKind(trace.SpanKindClient))
+	defer func() { EndSpan(span, err) }()
+
+	credentialsJson, err := base64.StdEncoding.DecodeString(cfg.FirebaseCredentialsJSONB64)
+	if err != nil {
+		return err
+	}
+
+	firebaseClient, err := fcm.NewClient(ctx, fcm.WithCredentialsJSON(credentialsJson))
+	if err != nil {
+		return err
+	}
+
+	_, err = firebaseClient.Send(ctx, msg)
+	if err != nil {
+		firebaseSendFailuresTotal.Inc()
+	}
+	return err
+}
+
+func CloseConnection(cfg *config.Config, connectionID string) error {
 	connectionInput := &apigatewaymanagementapi.DeleteConnectionInput{
 		ConnectionId: aws.String(connectionID),
 	}
 
-	_, err := NewAPIGatewaySession().DeleteConnection(connectionInput)
+	_, err := NewAPIGatewaySession(cfg).DeleteConnection(connectionInput)
 	return err
 }
 
 func WriteHttpError(w http.ResponseWriter, r *http.Request, err error, code int) {
 	_, file, no, _ := runtime.Caller(1)
-	logrus.WithFields(
+	Logger(r.Context()).WithFields(
 		logrus.Fields{
-			"path":           fmt.Sprintf("%s#%d", file, no),
-			"code":           code,
-			"remote-address": r.RemoteAddr,
+			"path": fmt.Sprintf("%s#%d", file, no),
+			"code": code,
 		},
 	).Warn(err.Error())
-	http.Error(w, err.Error(), http.StatusBadRequest)
+	http.Error(w, err.Error(), code)
 }
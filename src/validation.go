@@ -8,6 +8,38 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
+// reasons a notification can fail validation
+const (
+	CredentialsReason = "credentials"
+	TitleReason       = "title"
+	MessageReason     = "message"
+	LinkReason        = "link"
+	ImageReason       = "image"
+	DedupeReason      = "dedupe"
+	EscalationReason  = "escalation"
+	SizeReason        = "size"
+	CiphertextReason  = "ciphertext"
+	TopicReason       = "topic"
+)
+
+// ReasonHeader is the response header /api returns the reason a notification failed validation in
+const ReasonHeader = "X-Notifi-Reason"
+
+// ValidationError is returned when a notification fails validation
+type ValidationError struct {
+	Reason  string
+	Message string
+}
+
+// NewValidationError creates a ValidationError
+func NewValidationError(reason, message string) *ValidationError {
+	return &ValidationError{Reason: reason, Message: message}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
 // ValidVersionRegex is regex to match a valid version
 var ValidVersionRegex = regexp.MustCompile(`v?([0-9]+)(\.[0-9]+)?(\.[0-9]+)?`)
 
@@ -31,6 +63,14 @@ func IsValidCredentials(credentials string) bool {
 	return len(credentials) == credentialLen
 }
 
+// validTopicRegex matches the characters a topic can contain
+var validTopicRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]*$`)
+
+// IsValidTopic checks a string is a valid topic
+func IsValidTopic(topic string) bool {
+	return len(topic) <= maxTopic && validTopicRegex.MatchString(topic)
+}
+
 // IsValidURL checks a string is a URL
 func IsValidURL(url string) bool {
 	if url == "" {